@@ -0,0 +1,135 @@
+package turbograb
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"time"
+)
+
+// Certificates is a certificate chain, which is stored in JSON as a list of Certificate
+// instead of the full x509.Certificate structs
+type Certificates []*x509.Certificate
+
+// Certificate is the compact JSON form of a certificate. The PEM is all that's needed
+// to get the x509.Certificate back, the rest is there to make records easy to query.
+type Certificate struct {
+	PEM                string    `json:"pem" bson:"pem"`
+	SHA256             string    `json:"sha256" bson:"sha256"`
+	SPKISHA256         string    `json:"spkisha256,omitempty" bson:"spkisha256,omitempty"`
+	Subject            string    `json:"subject,omitempty" bson:"subject,omitempty"`
+	Issuer             string    `json:"issuer,omitempty" bson:"issuer,omitempty"`
+	SANs               []string  `json:"sans,omitempty" bson:"sans,omitempty"`
+	NotBefore          time.Time `json:"notbefore" bson:"notbefore"`
+	NotAfter           time.Time `json:"notafter" bson:"notafter"`
+	KeyType            string    `json:"keytype,omitempty" bson:"keytype,omitempty"`
+	KeySize            int       `json:"keysize,omitempty" bson:"keysize,omitempty"`
+	Serial             string    `json:"serial,omitempty" bson:"serial,omitempty"`
+	SignatureAlgorithm string    `json:"signaturealgorithm,omitempty" bson:"signaturealgorithm,omitempty"`
+	IsCA               bool      `json:"isca,omitempty" bson:"isca,omitempty"`
+}
+
+// NewCertificate returns the compact form of cert
+func NewCertificate(cert *x509.Certificate) Certificate {
+	keytype, keysize := KeyInfo(cert)
+	c := Certificate{
+		PEM:                string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		SHA256:             Fingerprint(cert),
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		KeyType:            keytype,
+		KeySize:            keysize,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		IsCA:               cert.IsCA,
+	}
+	if len(cert.RawSubjectPublicKeyInfo) > 0 {
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		c.SPKISHA256 = hex.EncodeToString(spki[:])
+	}
+	if cert.SerialNumber != nil {
+		c.Serial = cert.SerialNumber.Text(16)
+	}
+	c.SANs = append(c.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		c.SANs = append(c.SANs, ip.String())
+	}
+	c.SANs = append(c.SANs, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		c.SANs = append(c.SANs, uri.String())
+	}
+	return c
+}
+
+// X509 parses the PEM back into a x509.Certificate
+func (c Certificate) X509() (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(c.PEM))
+	if block == nil {
+		return nil, errors.New("no PEM data in certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Fingerprint returns the hex encoded SHA-256 of the DER encoded certificate
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// KeyInfo returns the public key algorithm and size in bits
+func KeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	case *dsa.PublicKey:
+		return "DSA", key.P.BitLen()
+	}
+	return cert.PublicKeyAlgorithm.String(), 0
+}
+
+func (cs Certificates) MarshalJSON() ([]byte, error) {
+	compact := make([]Certificate, len(cs))
+	for i, cert := range cs {
+		compact[i] = NewCertificate(cert)
+	}
+	return json.Marshal(compact)
+}
+
+// UnmarshalJSON reads the compact form, and also the full x509.Certificate structs older versions wrote
+func (cs *Certificates) UnmarshalJSON(data []byte) error {
+	// Everything else can be derived from these
+	var stored []struct {
+		PEM string `json:"pem"`
+		Raw []byte `json:"Raw"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*cs = make(Certificates, 0, len(stored))
+	for _, s := range stored {
+		var cert *x509.Certificate
+		var err error
+		if s.PEM != "" {
+			cert, err = Certificate{PEM: s.PEM}.X509()
+		} else {
+			cert, err = x509.ParseCertificate(s.Raw)
+		}
+		if err != nil {
+			return err
+		}
+		*cs = append(*cs, cert)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package turbograb
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testchain returns a leaf certificate and the CA that signed it
+func testchain(t *testing.T) Certificates {
+	cakey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caraw, err := x509.CreateCertificate(rand.Reader, catemplate, catemplate, &cakey.PublicKey, cakey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caraw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafkey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaftemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(0xabcdef),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com", "www.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("192.0.2.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	leafraw, err := x509.CreateCertificate(rand.Reader, leaftemplate, ca, &leafkey.PublicKey, cakey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(leafraw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Certificates{leaf, ca}
+}
+
+func samechain(t *testing.T, got, want Certificates) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%v certificates, want %v", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i].Raw, want[i].Raw) {
+			t.Errorf("certificate %v has different DER", i)
+		}
+	}
+}
+
+func TestCertificatesRoundtrip(t *testing.T) {
+	chain := testchain(t)
+	data, err := json.Marshal(Result{Site: "example.com", Certificates: chain})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var compact struct {
+		Certificates []Certificate `json:"certificates"`
+	}
+	if err = json.Unmarshal(data, &compact); err != nil {
+		t.Fatal(err)
+	}
+	leaf := compact.Certificates[0]
+	if leaf.SHA256 != Fingerprint(chain[0]) || leaf.Subject != "CN=example.com" || leaf.Issuer != "CN=Test CA" ||
+		leaf.KeyType != "ECDSA" || leaf.KeySize != 384 || leaf.Serial != "abcdef" || strings.Join(leaf.SANs, ",") != "example.com,www.example.com,192.0.2.1" {
+		t.Errorf("compact leaf %+v", leaf)
+	}
+	if !compact.Certificates[1].IsCA {
+		t.Error("CA is not a CA")
+	}
+
+	var result Result
+	if err = json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	samechain(t, result.Certificates, chain)
+}
+
+func TestCertificatesOldLayout(t *testing.T) {
+	chain := testchain(t)
+	// Older versions wrote the full x509.Certificate structs
+	old := struct {
+		Site         string              `json:"site"`
+		Certificates []*x509.Certificate `json:"certificates"`
+	}{"example.com", chain}
+	data, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := json.Marshal(Result{Site: "example.com", Certificates: chain})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files can have both, when newer results were added to an old archive
+	reader := NewReader(bytes.NewReader(append(data, current...)))
+	for i := 0; i < 2; i++ {
+		result, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		samechain(t, result.Certificates, chain)
+	}
+	if _, err = reader.Read(); err != io.EOF {
+		t.Errorf("got %v after the last result", err)
+	}
+}
+
+func TestCertificatesInvalid(t *testing.T) {
+	for _, data := range []string{
+		`[{"pem":"not a certificate"}]`,
+		`[{"Raw":"AAAA"}]`,
+		`{"pem":""}`,
+	} {
+		var cs Certificates
+		if err := json.Unmarshal([]byte(data), &cs); err == nil {
+			t.Errorf("%v gave no error", data)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package turbograb
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CertStore keeps every certificate once, as a PEM file named by its SHA-256 fingerprint.
+// Files are spread over subfolders named by the first two hex digits of the fingerprint.
+type CertStore struct {
+	folder string
+}
+
+// OpenCertStore opens the store in folder, creating it if needed
+func OpenCertStore(folder string) (*CertStore, error) {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return nil, err
+	}
+	return &CertStore{folder: folder}, nil
+}
+
+func (s *CertStore) filename(fingerprint string) string {
+	return filepath.Join(s.folder, fingerprint[:2], fingerprint+".pem")
+}
+
+// Put writes cert to the store unless it's already there, and returns its fingerprint
+func (s *CertStore) Put(cert *x509.Certificate) (string, error) {
+	fingerprint := Fingerprint(cert)
+	filename := s.filename(fingerprint)
+	if _, err := os.Stat(filename); err == nil {
+		return fingerprint, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return "", err
+	}
+	// Write somewhere else first, so nobody ever sees half a certificate
+	tmp, err := os.CreateTemp(filepath.Dir(filename), fingerprint+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	err = pem.Encode(tmp, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return fingerprint, nil
+}
+
+// Get reads the certificate with the given fingerprint from the store
+func (s *CertStore) Get(fingerprint string) (*x509.Certificate, error) {
+	if len(fingerprint) != 64 || strings.ContainsAny(fingerprint, `/\.`) {
+		return nil, errors.New("invalid fingerprint " + fingerprint)
+	}
+	data, err := os.ReadFile(s.filename(fingerprint))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data in " + s.filename(fingerprint))
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Walk calls fn for every certificate in the store, stopping at the first error fn returns
+func (s *CertStore) Walk(fn func(fingerprint string, cert *x509.Certificate) error) error {
+	return filepath.WalkDir(s.folder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".pem") {
+			return nil
+		}
+		fingerprint := strings.TrimSuffix(d.Name(), ".pem")
+		cert, err := s.Get(fingerprint)
+		if err != nil {
+			return err
+		}
+		return fn(fingerprint, cert)
+	})
+}
+
+// Resolve fills in Certificates from CertificateRefs, for results written with a certificate store
+func (s *CertStore) Resolve(result *Result) error {
+	if len(result.Certificates) > 0 || len(result.CertificateRefs) == 0 {
+		return nil
+	}
+	certs := make(Certificates, 0, len(result.CertificateRefs))
+	for _, fingerprint := range result.CertificateRefs {
+		cert, err := s.Get(fingerprint)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	result.Certificates = certs
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// adaptive controls how many producers are active using AIMD, with a slow start phase
+// that doubles the limit until the first sign of congestion. Producers with an index at
+// or above the current limit are parked. A nil *adaptive lets all producers run.
+type adaptive struct {
+	min, max   int
+	step       int
+	congestion float64
+
+	lock      sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	slowstart bool
+	stopped   bool
+
+	attempts, congested, succeeded int
+	lastthroughput                 float64
+}
+
+func newAdaptive(minimum, maximum int, congestion float64) *adaptive {
+	if minimum < 1 {
+		minimum = 1
+	}
+	if minimum > maximum {
+		minimum = maximum
+	}
+	step := maximum / 100
+	if step < 1 {
+		step = 1
+	}
+	a := &adaptive{
+		min:        minimum,
+		max:        maximum,
+		step:       step,
+		congestion: congestion,
+		limit:      minimum,
+		slowstart:  true,
+	}
+	a.cond = sync.NewCond(&a.lock)
+	return a
+}
+
+// wait blocks producer number i while it is not allowed to run
+func (a *adaptive) wait(i int) {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	for i >= a.limit && !a.stopped {
+		a.cond.Wait()
+	}
+	a.lock.Unlock()
+}
+
+// observe records the outcome of a single request attempt
+func (a *adaptive) observe(err error) {
+	if a == nil || err == errPolitenessWait {
+		return
+	}
+	a.lock.Lock()
+	a.attempts++
+	if err == nil {
+		a.succeeded++
+	} else if congested(err) {
+		a.congested++
+	}
+	a.lock.Unlock()
+}
+
+// current returns the number of producers allowed to run
+func (a *adaptive) current() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.limit
+}
+
+// adjust looks at the attempts since the last call and changes the limit accordingly
+func (a *adaptive) adjust(interval time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.attempts < 20 {
+		// Not enough to go on, wait for more data
+		return
+	}
+
+	rate := float64(a.congested) / float64(a.attempts)
+	throughput := float64(a.succeeded) / interval.Seconds()
+
+	if rate > a.congestion {
+		a.slowstart = false
+		a.limit = a.limit * 7 / 10
+		if a.limit < a.min {
+			a.limit = a.min
+		}
+	} else if throughput >= a.lastthroughput*0.9 {
+		if a.slowstart {
+			a.limit *= 2
+		} else {
+			a.limit += a.step
+		}
+		if a.limit > a.max {
+			a.limit = a.max
+		}
+		a.cond.Broadcast()
+	}
+
+	a.lastthroughput = throughput
+	a.attempts, a.congested, a.succeeded = 0, 0, 0
+}
+
+// run adjusts the limit every interval until stop is called, and calls report with the new limit
+func (a *adaptive) run(interval time.Duration, report func(limit int)) {
+	if a == nil {
+		return
+	}
+	for {
+		time.Sleep(interval)
+		a.lock.Lock()
+		stopped := a.stopped
+		a.lock.Unlock()
+		if stopped {
+			return
+		}
+		a.adjust(interval)
+		report(a.current())
+	}
+}
+
+// stop releases all parked producers so they can see that the queue is closed
+func (a *adaptive) stop() {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	a.stopped = true
+	a.cond.Broadcast()
+	a.lock.Unlock()
+}
+
+// congested returns true for errors that suggest we are overloading our own network, DNS or the targets
+func congested(err error) bool {
+	switch err {
+	case fasthttp.ErrTimeout, fasthttp.ErrDialTimeout, fasthttp.ErrTLSHandshakeTimeout:
+		return true
+	}
+
+	var dnserr *net.DNSError
+	if errors.As(err, &dnserr) {
+		return dnserr.IsTimeout || dnserr.IsTemporary
+	}
+
+	var neterr net.Error
+	if errors.As(err, &neterr) && neterr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.EADDRNOTAVAIL) ||
+		errors.Is(err, syscall.ENOBUFS) ||
+		errors.Is(err, syscall.EMFILE) ||
+		errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+)
+
+// backoff is an exponential backoff policy with jitter
+type backoff struct {
+	initial, max   time.Duration
+	factor, jitter float64
+}
+
+// delay returns how long to wait before retry number attempt (counting from zero)
+func (b backoff) delay(attempt int) time.Duration {
+	d := float64(b.initial) * math.Pow(b.factor, float64(attempt))
+	if b.max > 0 && d > float64(b.max) {
+		d = float64(b.max)
+	}
+	if b.jitter > 0 {
+		d += d * b.jitter * (rand.Float64()*2 - 1)
+	}
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+// retryafter parses a Retry-After header value, which is either a number of seconds or a HTTP date
+func retryafter(value []byte, now time.Time) (time.Duration, bool) {
+	s := strings.TrimSpace(string(value))
+	if s == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(s); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	when, err := dateparse.ParseAny(s)
+	if err != nil {
+		return 0, false
+	}
+	if d := when.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
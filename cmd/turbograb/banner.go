@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lkarlslund/turbograb"
+)
+
+// bannergrabber connects to a TCP port, optionally sends a probe, and keeps whatever the service says
+type bannergrabber struct {
+	dialer     *dialer
+	trace      *tracer
+	throttle   *adaptive
+	policy     backoff
+	timeout    time.Duration
+	idle       time.Duration // stop reading when the service has been quiet this long
+	port       string
+	probe      []byte
+	maxsize    int
+	retries    int
+	showerrors bool
+}
+
+// grab connects to site, on the port in site if it has one
+func (g *bannergrabber) grab(site string) turbograb.Result {
+	result := turbograb.Result{
+		Site: site,
+	}
+
+	host, port := site, g.port
+	if h, p, err := net.SplitHostPort(site); err == nil {
+		host, port = h, p
+	}
+	if port == "" {
+		result.Error = "no port given for site"
+		return result
+	}
+	addr := net.JoinHostPort(host, port)
+
+	var err error
+	retries := max(g.retries, 1) // always make one attempt
+	for retriesleft := retries; retriesleft > 0; {
+		wait := g.policy.delay(retries - retriesleft)
+
+		var conn net.Conn
+		var raw []byte
+
+		g.trace.begin()
+		conn, err = g.dialer.dial(g.trace, addr, nil)
+		if err == nil {
+			result.IPaddress = conn.RemoteAddr().String()
+			raw, err = g.talk(conn)
+			conn.Close()
+		}
+		g.trace.end()
+		g.throttle.observe(err)
+
+		if err == nil {
+			result.Raw = raw
+			result.Protocol = identify(raw)
+			if len(raw) == 0 {
+				result.Warnings = append(result.Warnings, "no_banner")
+			}
+			return result
+		}
+
+		if err == errPolitenessWait {
+			continue // try again, but it doesn't cost a retry
+		} else if _, ok := err.(*net.DNSError); ok {
+			break
+		} else if errors.Is(err, syscall.ECONNREFUSED) {
+			result.Warnings = append(result.Warnings, "connection_refused")
+			break
+		}
+
+		if g.showerrors {
+			log.Println("Banner from", addr, "error:", err.Error())
+		}
+
+		// No point in waiting after the last attempt
+		if retriesleft > 1 {
+			time.Sleep(wait)
+		}
+		retriesleft--
+	}
+
+	result.Error = err.Error()
+	return result
+}
+
+// talk sends the probe and reads until the service hangs up, goes quiet or has said too much
+func (g *bannergrabber) talk(conn net.Conn) ([]byte, error) {
+	deadline := time.Now().Add(g.timeout)
+	if len(g.probe) > 0 {
+		conn.SetWriteDeadline(deadline)
+		if _, err := conn.Write(g.probe); err != nil {
+			return nil, err
+		}
+	}
+
+	var raw []byte
+	buf := make([]byte, 4096)
+	for len(raw) < g.maxsize {
+		readdeadline := time.Now().Add(g.idle)
+		if readdeadline.After(deadline) {
+			readdeadline = deadline
+		}
+		conn.SetReadDeadline(readdeadline)
+
+		n, err := conn.Read(buf)
+		raw = append(raw, buf[:n]...)
+		if err != nil {
+			var neterr net.Error
+			if errors.As(err, &neterr) && neterr.Timeout() {
+				// Quiet services are fine, they may be waiting for a probe we didn't send
+				break
+			}
+			if len(raw) > 0 || err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+	}
+	if len(raw) > g.maxsize {
+		raw = raw[:g.maxsize]
+	}
+	return raw, nil
+}
+
+// identify guesses the protocol from what the service said first
+func identify(raw []byte) string {
+	switch {
+	case len(raw) == 0:
+		return "unknown"
+	case bytes.HasPrefix(raw, []byte("SSH-")):
+		return "ssh"
+	case bytes.HasPrefix(raw, []byte("HTTP/")):
+		return "http"
+	case bytes.HasPrefix(raw, []byte("220")) && bytes.Contains(bytes.ToUpper(raw[:min(len(raw), 256)]), []byte("SMTP")):
+		return "smtp"
+	case bytes.HasPrefix(raw, []byte("220")):
+		return "ftp"
+	case bytes.HasPrefix(raw, []byte("* OK")):
+		return "imap"
+	case bytes.HasPrefix(raw, []byte("+OK")):
+		return "pop3"
+	case bytes.HasPrefix(raw, []byte("-ERR")), bytes.HasPrefix(raw, []byte("-NOAUTH")), bytes.HasPrefix(raw, []byte("+PONG")), bytes.HasPrefix(raw, []byte("-DENIED")):
+		return "redis"
+	case len(raw) > 5 && raw[4] == 0x0a && int(raw[0])|int(raw[1])<<8|int(raw[2])<<16 == len(raw)-4:
+		// MySQL greeting is a single packet with protocol version 10
+		return "mysql"
+	case len(raw) > 5 && raw[0] == 0x15 && raw[1] == 0x03:
+		return "tls"
+	}
+	return "unknown"
+}
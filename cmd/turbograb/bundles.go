@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// bundleresponse is what a validator gets to decide if a path was really found
+type bundleresponse struct {
+	code       int
+	header     string
+	body       []byte
+	redirected bool
+}
+
+// bundlepath is a path to grab and how to tell a real hit from a soft 404
+type bundlepath struct {
+	path  string
+	valid func(r bundleresponse) bool
+}
+
+// bundles are the named path lists for --bundle
+var bundles = map[string][]bundlepath{
+	"wellknown": {
+		{"/robots.txt", func(r bundleresponse) bool {
+			return ok(r) && !ishtml(r.body) && containsfold(r.body, "user-agent", "disallow", "sitemap")
+		}},
+		{"/.well-known/security.txt", func(r bundleresponse) bool {
+			return ok(r) && !ishtml(r.body) && containsfold(r.body, "contact:")
+		}},
+		{"/sitemap.xml", func(r bundleresponse) bool {
+			return ok(r) && containsfold(r.body, "<urlset", "<sitemapindex")
+		}},
+		{"/humans.txt", func(r bundleresponse) bool {
+			return ok(r) && !ishtml(r.body) && len(bytes.TrimSpace(r.body)) > 0
+		}},
+		{"/.well-known/change-password", func(r bundleresponse) bool {
+			// Should redirect to the real change password page
+			return ok(r) && r.redirected
+		}},
+		{"/.well-known/openid-configuration", func(r bundleresponse) bool {
+			var config struct {
+				Issuer string `json:"issuer"`
+			}
+			return ok(r) && json.Unmarshal(r.body, &config) == nil && config.Issuer != ""
+		}},
+	},
+	"admin": {
+		{"/admin/", haspasswordfield},
+		{"/login", haspasswordfield},
+		{"/wp-login.php", func(r bundleresponse) bool {
+			return ok(r) && containsfold(r.body, "user_login", "wp-submit")
+		}},
+		{"/administrator/", func(r bundleresponse) bool {
+			return haspasswordfield(r) && containsfold(r.body, "joomla")
+		}},
+		{"/phpmyadmin/", func(r bundleresponse) bool {
+			return ok(r) && containsfold(r.body, "phpmyadmin")
+		}},
+		{"/manager/html", func(r bundleresponse) bool {
+			// Tomcat asks for credentials
+			return r.code == 401 && strings.Contains(r.header, "Tomcat Manager")
+		}},
+		{"/server-status", func(r bundleresponse) bool {
+			return ok(r) && containsfold(r.body, "apache server status")
+		}},
+	},
+	"vcs": {
+		{"/.git/HEAD", func(r bundleresponse) bool {
+			return ok(r) && githead.Match(bytes.TrimSpace(r.body))
+		}},
+		{"/.git/config", func(r bundleresponse) bool {
+			return ok(r) && !ishtml(r.body) && bytes.Contains(r.body, []byte("[core]"))
+		}},
+		{"/.svn/entries", func(r bundleresponse) bool {
+			return ok(r) && svnentries.Match(r.body)
+		}},
+		{"/.svn/wc.db", func(r bundleresponse) bool {
+			return ok(r) && bytes.HasPrefix(r.body, []byte("SQLite format 3\x00"))
+		}},
+		{"/.hg/requires", func(r bundleresponse) bool {
+			return ok(r) && !ishtml(r.body) && containsfold(r.body, "revlogv1", "store")
+		}},
+		{"/.env", func(r bundleresponse) bool {
+			return ok(r) && !ishtml(r.body) && dotenv.Match(r.body)
+		}},
+	},
+}
+
+var (
+	githead    = regexp.MustCompile(`^(ref: refs/\S+|[0-9a-f]{40})$`)
+	svnentries = regexp.MustCompile(`^\d+\n`)
+	dotenv     = regexp.MustCompile(`(?m)^[A-Za-z_][A-Za-z0-9_]*=`)
+	password   = regexp.MustCompile(`(?i)<input[^>]+type=["']?password`)
+)
+
+func ok(r bundleresponse) bool {
+	return r.code == 200
+}
+
+// ishtml tells if body looks like a web page, which is what most soft 404s are
+func ishtml(body []byte) bool {
+	start := bytes.ToLower(bytes.TrimSpace(body[:min(len(body), 512)]))
+	return bytes.HasPrefix(start, []byte("<!doctype html")) || bytes.HasPrefix(start, []byte("<html")) || bytes.Contains(start, []byte("<head"))
+}
+
+// containsfold tells if body contains any of the lowercase needles, ignoring case
+func containsfold(body []byte, needles ...string) bool {
+	lower := bytes.ToLower(body)
+	for _, needle := range needles {
+		if bytes.Contains(lower, []byte(needle)) {
+			return true
+		}
+	}
+	return false
+}
+
+func haspasswordfield(r bundleresponse) bool {
+	return ok(r) && password.Match(r.body)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lkarlslund/turbograb"
+	"github.com/spf13/pflag"
+)
+
+// certfindings are the problems certreport looks for, in the order they're reported
+var certfindings = []string{"expiring", "expired", "selfsigned", "wronghost", "weakkey", "weaksignature", "wildcard"}
+
+// sitecert is the leaf certificate a site presented and what's wrong with its chain
+type sitecert struct {
+	Site        string    `json:"site"`
+	IP          string    `json:"ip,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotAfter    time.Time `json:"notafter"`
+	DaysLeft    int       `json:"daysleft"`
+	Findings    []string  `json:"findings,omitempty"`
+}
+
+// issuercerts counts the sites with each finding for certificates from one issuer
+type issuercerts struct {
+	Issuer       string         `json:"issuer"`
+	Sites        int            `json:"sites"`
+	Certificates int            `json:"certificates"`
+	Findings     map[string]int `json:"findings,omitempty"`
+
+	fingerprints map[string]struct{}
+}
+
+// certreportcommand reads archives and reports on certificate expiry and hygiene
+func certreportcommand(args []string) {
+	flags := pflag.NewFlagSet("certreport", pflag.ExitOnError)
+	input := flags.String("input", "*.json", "Result files to read (JSON format, optionally LZ4 compressed)")
+	certstorefolder := flags.String("certstore", "", "Certificate store the results were written with")
+	days := flags.Int("days", 30, "Report certificates expiring within this many days")
+	groupby := flags.String("groupby", "issuer", "Group report by (issuer, site)")
+	format := flags.String("format", "markdown", "Report format (csv, json, markdown)")
+	all := flags.Bool("all", false, "Include sites without findings")
+	output := flags.String("output", "", "Output file (default is stdout)")
+	flags.Parse(args)
+
+	switch *groupby {
+	case "issuer", "site":
+	default:
+		log.Println("Unknown grouping", *groupby)
+		os.Exit(1)
+	}
+	switch *format {
+	case "csv", "json", "markdown":
+	default:
+		log.Println("Unknown format", *format)
+		os.Exit(1)
+	}
+
+	files, err := filepath.Glob(*input)
+	if err != nil {
+		log.Println("Error locating files to process:", err)
+		os.Exit(1)
+	}
+
+	var store *turbograb.CertStore
+	if *certstorefolder != "" {
+		store, err = turbograb.OpenCertStore(*certstorefolder)
+		if err != nil {
+			log.Println("Error opening certificate store:", err)
+			os.Exit(1)
+		}
+	}
+
+	now := time.Now()
+	sites := make(map[string]sitecert)
+	for _, file := range files {
+		reader, err := turbograb.OpenFile(file)
+		if err != nil {
+			log.Printf("Error opening %v: %v", file, err)
+			continue
+		}
+		if store != nil {
+			reader.UseCertStore(store)
+		}
+		for {
+			result, err := reader.Read()
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("Error reading %v: %v", file, err)
+				}
+				break
+			}
+			if len(result.Certificates) == 0 {
+				continue
+			}
+			// The last record for a site wins
+			sites[result.Site] = examine(result, now, *days)
+		}
+		reader.Close()
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		outfile, err := os.Create(*output)
+		if err != nil {
+			log.Println("Error creating output file:", err)
+			os.Exit(1)
+		}
+		defer outfile.Close()
+		out = outfile
+	}
+	bw := bufio.NewWriter(out)
+	defer bw.Flush()
+
+	var sitelist []sitecert
+	for _, sc := range sites {
+		if *all || len(sc.Findings) > 0 {
+			sitelist = append(sitelist, sc)
+		}
+	}
+	slices.SortFunc(sitelist, func(a, b sitecert) int {
+		return strings.Compare(a.Site, b.Site)
+	})
+
+	if *groupby == "site" {
+		err = reportsites(bw, *format, sitelist)
+	} else {
+		err = reportissuers(bw, *format, groupissuers(sitelist))
+	}
+	if err != nil {
+		log.Println("Error writing report:", err)
+		os.Exit(1)
+	}
+}
+
+// examine looks at the chain in result
+func examine(result turbograb.Result, now time.Time, days int) sitecert {
+	leaf := result.Certificates[0]
+	sc := sitecert{
+		Site:        result.Site,
+		IP:          result.IPaddress,
+		Fingerprint: turbograb.Fingerprint(leaf),
+		Subject:     leaf.Subject.String(),
+		Issuer:      leaf.Issuer.String(),
+		NotAfter:    leaf.NotAfter,
+		DaysLeft:    int(leaf.NotAfter.Sub(now).Hours() / 24),
+	}
+
+	switch {
+	case now.After(leaf.NotAfter):
+		sc.Findings = append(sc.Findings, "expired")
+	case leaf.NotAfter.Before(now.AddDate(0, 0, days)):
+		sc.Findings = append(sc.Findings, "expiring")
+	}
+
+	if bytes.Equal(leaf.RawSubject, leaf.RawIssuer) {
+		sc.Findings = append(sc.Findings, "selfsigned")
+	}
+
+	if result.Validation != nil {
+		if !result.Validation.HostnameValid {
+			sc.Findings = append(sc.Findings, "wronghost")
+		}
+	} else {
+		host := result.Site
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if leaf.VerifyHostname(host) != nil {
+			sc.Findings = append(sc.Findings, "wronghost")
+		}
+	}
+
+	var weak, weaksigned bool
+	for _, cert := range result.Certificates {
+		weak = weak || weakkey(cert) != ""
+		weaksigned = weaksigned || (!bytes.Equal(cert.RawSubject, cert.RawIssuer) && weaksignature(cert.SignatureAlgorithm))
+	}
+	if weak {
+		sc.Findings = append(sc.Findings, "weakkey")
+	}
+	if weaksigned {
+		sc.Findings = append(sc.Findings, "weaksignature")
+	}
+
+	for _, name := range leaf.DNSNames {
+		if strings.HasPrefix(name, "*.") {
+			sc.Findings = append(sc.Findings, "wildcard")
+			break
+		}
+	}
+	return sc
+}
+
+func groupissuers(sitelist []sitecert) []*issuercerts {
+	issuers := make(map[string]*issuercerts)
+	for _, sc := range sitelist {
+		ic := issuers[sc.Issuer]
+		if ic == nil {
+			ic = &issuercerts{
+				Issuer:       sc.Issuer,
+				Findings:     make(map[string]int),
+				fingerprints: make(map[string]struct{}),
+			}
+			issuers[sc.Issuer] = ic
+		}
+		ic.Sites++
+		ic.fingerprints[sc.Fingerprint] = struct{}{}
+		ic.Certificates = len(ic.fingerprints)
+		for _, finding := range sc.Findings {
+			ic.Findings[finding]++
+		}
+	}
+
+	var result []*issuercerts
+	for _, ic := range issuers {
+		result = append(result, ic)
+	}
+	slices.SortFunc(result, func(a, b *issuercerts) int {
+		if a.Sites != b.Sites {
+			return b.Sites - a.Sites
+		}
+		return strings.Compare(a.Issuer, b.Issuer)
+	})
+	return result
+}
+
+func reportsites(w io.Writer, format string, sitelist []sitecert) error {
+	header := []string{"site", "ip", "subject", "issuer", "notafter", "daysleft", "fingerprint", "findings"}
+	row := func(sc sitecert) []string {
+		return []string{sc.Site, sc.IP, sc.Subject, sc.Issuer, sc.NotAfter.Format(time.RFC3339), strconv.Itoa(sc.DaysLeft), sc.Fingerprint, strings.Join(sc.Findings, " ")}
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(sitelist)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		for _, sc := range sitelist {
+			cw.Write(row(sc))
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	fmt.Fprintf(w, "# Certificate report by site\n\n%v sites\n\n", len(sitelist))
+	writemarkdownrow(w, header)
+	writemarkdownseparator(w, len(header))
+	for _, sc := range sitelist {
+		writemarkdownrow(w, row(sc))
+	}
+	return nil
+}
+
+func reportissuers(w io.Writer, format string, issuers []*issuercerts) error {
+	header := append([]string{"issuer", "sites", "certificates"}, certfindings...)
+	row := func(ic *issuercerts) []string {
+		fields := []string{ic.Issuer, strconv.Itoa(ic.Sites), strconv.Itoa(ic.Certificates)}
+		for _, finding := range certfindings {
+			fields = append(fields, strconv.Itoa(ic.Findings[finding]))
+		}
+		return fields
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(issuers)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		for _, ic := range issuers {
+			cw.Write(row(ic))
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	fmt.Fprintf(w, "# Certificate report by issuer\n\n%v issuers\n\n", len(issuers))
+	writemarkdownrow(w, header)
+	writemarkdownseparator(w, len(header))
+	for _, ic := range issuers {
+		writemarkdownrow(w, row(ic))
+	}
+	return nil
+}
+
+func writemarkdownseparator(w io.Writer, columns int) {
+	fmt.Fprintf(w, "|%v\n", strings.Repeat(" --- |", columns))
+}
+
+func writemarkdownrow(w io.Writer, fields []string) {
+	escaped := make([]string, len(fields))
+	for i, field := range fields {
+		escaped[i] = strings.ReplaceAll(field, "|", `\|`)
+	}
+	fmt.Fprintf(w, "| %v |\n", strings.Join(escaped, " | "))
+}
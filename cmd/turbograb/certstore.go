@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/lkarlslund/turbograb"
+	"github.com/spf13/pflag"
+)
+
+// certstorecommand searches or exports the certificates in a store written with --certstore
+func certstorecommand(args []string) {
+	flags := pflag.NewFlagSet("certstore", pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: turbograb certstore search|export --store=folder [filters]")
+		flags.PrintDefaults()
+	}
+	folder := flags.String("store", "", "Certificate store folder")
+	output := flags.String("output", "", "Output file (default is stdout)")
+	format := flags.String("format", "pem", "Export format (pem, json)")
+	subject := flags.String("subject", "", "Regular expression the subject must match")
+	issuer := flags.String("issuer", "", "Regular expression the issuer must match")
+	san := flags.String("san", "", "Regular expression one of the subject alternative names must match")
+	fingerprint := flags.String("fingerprint", "", "Fingerprint must start with this")
+	expiresbefore := flags.String("expiresbefore", "", "Only certificates that expire before this date")
+	caonly := flags.Bool("ca", false, "Only CA certificates")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *folder == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+	command := flags.Arg(0)
+	switch command {
+	case "search":
+	case "export":
+		if *format != "pem" && *format != "json" {
+			log.Println("Unknown format", *format)
+			os.Exit(1)
+		}
+	default:
+		log.Println("Unknown certstore command", command)
+		os.Exit(1)
+	}
+
+	var filter certfilter
+	var err error
+	if filter.subject, err = compileoptional(*subject); err == nil {
+		if filter.issuer, err = compileoptional(*issuer); err == nil {
+			filter.san, err = compileoptional(*san)
+		}
+	}
+	if err != nil {
+		log.Println("Error compiling regular expression:", err)
+		os.Exit(1)
+	}
+	if *expiresbefore != "" {
+		filter.expiresbefore, err = dateparse.ParseAny(*expiresbefore)
+		if err != nil {
+			log.Println("Error parsing date:", err)
+			os.Exit(1)
+		}
+	}
+	filter.fingerprint = strings.ToLower(*fingerprint)
+	filter.caonly = *caonly
+
+	if _, err := os.Stat(*folder); err != nil {
+		log.Println("Error opening certificate store:", err)
+		os.Exit(1)
+	}
+	store, err := turbograb.OpenCertStore(*folder)
+	if err != nil {
+		log.Println("Error opening certificate store:", err)
+		os.Exit(1)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		outfile, err := os.Create(*output)
+		if err != nil {
+			log.Println("Error creating output file:", err)
+			os.Exit(1)
+		}
+		defer outfile.Close()
+		out = outfile
+	}
+	bw := bufio.NewWriter(out)
+	defer bw.Flush()
+
+	encoder := json.NewEncoder(bw)
+	var matches int
+	err = store.Walk(func(fingerprint string, cert *x509.Certificate) error {
+		if !filter.match(fingerprint, cert) {
+			return nil
+		}
+		matches++
+		switch {
+		case command == "search":
+			compact := turbograb.NewCertificate(cert)
+			_, err := fmt.Fprintf(bw, "%v\t%v\t%v\t%v\n", fingerprint, cert.NotAfter.Format(time.RFC3339), compact.Subject, strings.Join(compact.SANs, ","))
+			return err
+		case *format == "json":
+			return encoder.Encode(turbograb.NewCertificate(cert))
+		default:
+			return pem.Encode(bw, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+	})
+	if err != nil {
+		log.Println("Error reading certificate store:", err)
+		os.Exit(1)
+	}
+	log.Printf("%v certificates matched", matches)
+}
+
+// certfilter selects certificates from the store, blank criteria match everything
+type certfilter struct {
+	subject, issuer, san *regexp.Regexp
+	fingerprint          string
+	expiresbefore        time.Time
+	caonly               bool
+}
+
+func (f certfilter) match(fingerprint string, cert *x509.Certificate) bool {
+	if !strings.HasPrefix(fingerprint, f.fingerprint) {
+		return false
+	}
+	if f.caonly && !cert.IsCA {
+		return false
+	}
+	if !f.expiresbefore.IsZero() && !cert.NotAfter.Before(f.expiresbefore) {
+		return false
+	}
+	if f.subject != nil && !f.subject.MatchString(cert.Subject.String()) {
+		return false
+	}
+	if f.issuer != nil && !f.issuer.MatchString(cert.Issuer.String()) {
+		return false
+	}
+	if f.san != nil {
+		for _, name := range turbograb.NewCertificate(cert).SANs {
+			if f.san.MatchString(name) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func compileoptional(expression string) (*regexp.Regexp, error) {
+	if expression == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expression)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+)
+
+// crawl keeps track of the pages seen on one site, so links are followed breadth first
+// without grabbing the same page twice
+type crawl struct {
+	maxdepth int
+	maxpages int
+	domain   string // registrable domain links must be in, blank means the site's own host names only
+
+	hosts map[string]struct{}
+	seen  map[string]struct{}
+	pages int
+}
+
+// newCrawl starts crawling site, returns nil if crawling is off
+func newCrawl(site string, maxdepth, maxpages int, samedomain bool) *crawl {
+	if maxdepth <= 0 {
+		return nil
+	}
+	c := &crawl{
+		maxdepth: maxdepth,
+		maxpages: maxpages,
+		hosts:    make(map[string]struct{}),
+		seen:     make(map[string]struct{}),
+	}
+	name := normalizename(site)
+	c.hosts[name] = struct{}{}
+	if samedomain {
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+			c.domain = domain
+		}
+	}
+	return c
+}
+
+// visit records a page that was grabbed without being found by the crawl, the host it ended up on
+// after redirects is then in scope too
+func (c *crawl) visit(pageurl string) {
+	if c == nil {
+		return
+	}
+	u, err := url.Parse(pageurl)
+	if err != nil {
+		return
+	}
+	c.hosts[normalizename(u.Host)] = struct{}{}
+	if key := crawlkey(u); key != "" {
+		if _, found := c.seen[key]; !found {
+			c.seen[key] = struct{}{}
+			c.pages++
+		}
+	}
+}
+
+// links returns the new pages linked from a page at depth that should be grabbed next
+func (c *crawl) links(pageurl string, depth int, header, body string) []*url.URL {
+	if c == nil || depth >= c.maxdepth || c.pages >= c.maxpages {
+		return nil
+	}
+	if !htmlpage(header, body) {
+		return nil
+	}
+	base, err := url.Parse(pageurl)
+	if err != nil {
+		return nil
+	}
+
+	var result []*url.URL
+	for _, link := range htmllinks(base, body) {
+		if c.pages >= c.maxpages {
+			break
+		}
+		if !c.inscope(link.Host) {
+			continue
+		}
+		key := crawlkey(link)
+		if _, found := c.seen[key]; found || key == "" {
+			continue
+		}
+		c.seen[key] = struct{}{}
+		c.pages++
+		result = append(result, link)
+	}
+	return result
+}
+
+// htmlpage tells if a response is a web page, going by the content type or what the body looks like
+func htmlpage(header, body string) bool {
+	return ishtml([]byte(body)) || containsfold([]byte(header), "content-type: text/html", "content-type: application/xhtml")
+}
+
+func (c *crawl) inscope(host string) bool {
+	name := normalizename(host)
+	if _, found := c.hosts[name]; found {
+		return true
+	}
+	if c.domain == "" {
+		return false
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+	return err == nil && domain == c.domain
+}
+
+// crawlkey is the normalized form of a URL used to tell if a page was seen already
+func crawlkey(u *url.URL) string {
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return ""
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	key := u.Scheme + "://" + strings.ToLower(u.Host) + path
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+	return key
+}
+
+// htmllinks returns the absolute http and https URLs linked from a page, without fragments
+func htmllinks(base *url.URL, body string) []*url.URL {
+	var result []*url.URL
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			return result
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		name, hasattr := tokenizer.TagName()
+		var want string
+		switch string(name) {
+		case "a", "area", "base":
+			want = "href"
+		case "frame", "iframe":
+			want = "src"
+		default:
+			continue
+		}
+		for hasattr {
+			var key, value []byte
+			key, value, hasattr = tokenizer.TagAttr()
+			if string(key) != want {
+				continue
+			}
+			ref, err := url.Parse(string(bytes.TrimSpace(value)))
+			if err != nil {
+				break
+			}
+			link := base.ResolveReference(ref)
+			if string(name) == "base" {
+				// Later links are relative to this
+				base = link
+				break
+			}
+			if link.Scheme != "http" && link.Scheme != "https" {
+				break
+			}
+			link.Fragment = ""
+			link.RawFragment = ""
+			result = append(result, link)
+			break
+		}
+	}
+}
+
+// pathlist is a list of paths to try on a site until one works, crawled pages bring their own host and protocol
+type pathlist struct {
+	paths    []string
+	host     string
+	protocol string
+	depth    int
+}
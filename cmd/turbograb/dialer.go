@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// dialer makes new connections for all producers, applying the politeness limits and
+// recording phase timings in the calling producers tracer
+type dialer struct {
+	timeout time.Duration
+	limits  *politeness
+	sni     string // none, host or custom
+	sniname string // sent when sni is custom
+}
+
+// dial connects to addr, and if config is not nil does the TLS handshake too
+func (d *dialer) dial(t *tracer, addr string, config *tls.Config) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	releasedomain, err := d.limits.domain(host, time.Now().Add(d.timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	start := time.Now()
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	t.timings.DNS = time.Since(start)
+	if err != nil {
+		releasedomain()
+		return nil, err
+	}
+
+	var rawconn net.Conn
+	var releaseip func()
+	err = &net.DNSError{Err: "no IPv4 address found", Name: host, IsNotFound: true}
+	for _, ip := range ips {
+		if ip.IP.To4() == nil {
+			continue
+		}
+		releaseip, err = d.limits.ip(ip.IP, time.Now().Add(d.timeout))
+		if err != nil {
+			continue
+		}
+		start = time.Now()
+		rawconn, err = net.DialTimeout("tcp4", net.JoinHostPort(ip.IP.String(), port), d.timeout)
+		t.timings.Connect += time.Since(start)
+		if err == nil {
+			break
+		}
+		releaseip()
+	}
+	if err != nil {
+		releasedomain()
+		return nil, err
+	}
+
+	t.conn = &tracedConn{
+		Conn: &releaseConn{
+			Conn: rawconn,
+			release: func() {
+				releaseip()
+				releasedomain()
+			},
+		},
+	}
+
+	if config == nil {
+		return t.conn, nil
+	}
+
+	return d.handshake(t, t.conn, host, config)
+}
+
+// handshake does the TLS handshake on a connection made by dial, which may already have been used for
+// something else, like asking the server to STARTTLS
+func (d *dialer) handshake(t *tracer, conn net.Conn, host string, config *tls.Config) (net.Conn, error) {
+	if config.ServerName == "" {
+		config = config.Clone()
+		switch d.sni {
+		case "none":
+			// crypto/tls only leaves out SNI when there's no server name, and then it can't verify the chain by itself
+			if !config.InsecureSkipVerify {
+				config.InsecureSkipVerify = true
+				config.VerifyConnection = func(state tls.ConnectionState) error {
+					if err := verifychain(state.PeerCertificates, host); err != nil {
+						// Same error as crypto/tls gives, so failing back to insecure works the same way
+						return &tls.CertificateVerificationError{UnverifiedCertificates: state.PeerCertificates, Err: err}
+					}
+					return nil
+				}
+			}
+		case "custom":
+			config.ServerName = d.sniname
+		default:
+			config.ServerName = host
+		}
+	}
+
+	start := time.Now()
+	tlsconn := tls.Client(conn, config)
+	tlsconn.SetDeadline(start.Add(d.timeout))
+	err := tlsconn.Handshake()
+	t.timings.TLSHandshake = time.Since(start)
+	if err != nil {
+		tlsconn.Close()
+		return nil, err
+	}
+	tlsconn.SetDeadline(time.Time{})
+
+	// Resumed sessions don't call VerifyPeerCertificate, but that's where the chain gets stored
+	if state := tlsconn.ConnectionState(); state.DidResume && config.VerifyPeerCertificate != nil {
+		rawcerts := make([][]byte, len(state.PeerCertificates))
+		for i, cert := range state.PeerCertificates {
+			rawcerts[i] = cert.Raw
+		}
+		config.VerifyPeerCertificate(rawcerts, state.VerifiedChains)
+	}
+
+	// Handshake traffic doesn't count towards time to first byte
+	t.conn.wrote = time.Time{}
+	t.conn.read = time.Time{}
+
+	return tlsconn, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lkarlslund/turbograb"
+	"github.com/schollz/progressbar/v3"
+	"github.com/spf13/pflag"
+	"golang.org/x/net/publicsuffix"
+)
+
+// discovery collects host names from certificates that are in scope and haven't been seen before,
+// and either queues them for the running scan, writes them to a new sitelist, or both
+type discovery struct {
+	scope  []string
+	limit  int
+	queue  bool
+	output io.Writer
+
+	lock     sync.Mutex
+	wake     *sync.Cond
+	known    map[string]struct{}
+	pending  []string
+	inflight int
+	found    int
+}
+
+// newDiscovery makes a discovery that knows about sites already, scope defaults to their registrable domains
+func newDiscovery(sites, scope []string, limit int, queue bool, output io.Writer) *discovery {
+	d := &discovery{
+		limit:  limit,
+		queue:  queue,
+		output: output,
+		known:  make(map[string]struct{}),
+	}
+	d.wake = sync.NewCond(&d.lock)
+
+	scopes := make(map[string]struct{})
+	for _, s := range scope {
+		scopes[normalizename(s)] = struct{}{}
+	}
+	for _, site := range sites {
+		name := normalizename(site)
+		if name == "" {
+			continue
+		}
+		d.known[name] = struct{}{}
+		if len(scope) == 0 && net.ParseIP(name) == nil {
+			if domain, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+				scopes[domain] = struct{}{}
+			}
+		}
+	}
+	for s := range scopes {
+		if s != "" {
+			d.scope = append(d.scope, s)
+		}
+	}
+	return d
+}
+
+// normalizename turns a site or certificate name into a bare lowercase host name, wildcards become their parent domain
+func normalizename(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+	name = strings.TrimPrefix(name, "*.")
+	return strings.TrimSuffix(name, ".")
+}
+
+// inscope tells if name is one of the scope domains or below one
+func (d *discovery) inscope(name string) bool {
+	for _, s := range d.scope {
+		if name == s || strings.HasSuffix(name, "."+s) {
+			return true
+		}
+	}
+	return false
+}
+
+// add looks at the names in the leaf certificate of a chain
+func (d *discovery) add(certs []*x509.Certificate) {
+	if d == nil || len(certs) == 0 {
+		return
+	}
+	names := certnames(certs[0])
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, name := range names {
+		if d.found >= d.limit {
+			return
+		}
+		name = normalizename(name)
+		if name == "" || !d.inscope(name) {
+			continue
+		}
+		if _, seen := d.known[name]; seen {
+			continue
+		}
+		d.known[name] = struct{}{}
+		d.found++
+		if d.output != nil {
+			fmt.Fprintln(d.output, name)
+		}
+		if d.queue {
+			d.pending = append(d.pending, name)
+			d.inflight++
+			d.wake.Broadcast()
+		}
+	}
+}
+
+// queued is called for every site put in the producer queue, and done when a producer has finished it
+func (d *discovery) queued() {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	d.inflight++
+	d.lock.Unlock()
+}
+
+func (d *discovery) done() {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	d.inflight--
+	d.wake.Broadcast()
+	d.lock.Unlock()
+}
+
+// feed queues discovered names until every site has been grabbed without finding anything new
+func (d *discovery) feed(queue chan<- string, pb *progressbar.ProgressBar) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for {
+		for len(d.pending) > 0 {
+			name := d.pending[0]
+			d.pending = d.pending[1:]
+			d.lock.Unlock()
+			pb.ChangeMax(pb.GetMax() + 1)
+			queue <- name
+			pb.Add(1)
+			d.lock.Lock()
+		}
+		if d.inflight == 0 {
+			return
+		}
+		d.wake.Wait()
+	}
+}
+
+// certnames returns the DNS names and the common name of cert, if it looks like a host name
+func certnames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	if cn := cert.Subject.CommonName; strings.Contains(cn, ".") && !strings.ContainsAny(cn, " /:@") {
+		names = append(names, cn)
+	}
+	return names
+}
+
+// discovercommand reads existing JSON archives and writes the in scope names from their certificates
+// that are not in the sitelist to a new sitelist
+func discovercommand(args []string) {
+	flags := pflag.NewFlagSet("discover", pflag.ExitOnError)
+	input := flags.String("input", "*.json", "Result files to read (JSON format, optionally LZ4 compressed)")
+	sitelist := flags.String("sitelist", "", "File with sites that were already scanned")
+	scope := flags.StringSlice("scope", nil, "Domains to keep names below (default is the registrable domains in the sitelist)")
+	limit := flags.Int("maxdiscovered", 1000000, "Max number of names to discover")
+	certstorefolder := flags.String("certstore", "", "Certificate store the results were written with")
+	output := flags.String("output", "", "Output file for the new sitelist (default is stdout)")
+	flags.Parse(args)
+
+	files, err := filepath.Glob(*input)
+	if err != nil {
+		log.Println("Error locating files to process:", err)
+		os.Exit(1)
+	}
+
+	var sites []string
+	if *sitelist != "" {
+		rawsites, err := os.ReadFile(*sitelist)
+		if err != nil {
+			log.Println("Error reading sitelist file:", err)
+			os.Exit(1)
+		}
+		sites = strings.Split(string(rawsites), "\n")
+	}
+	if len(sites) == 0 && len(*scope) == 0 {
+		log.Println("Either a sitelist or a scope is needed")
+		os.Exit(1)
+	}
+
+	var store *turbograb.CertStore
+	if *certstorefolder != "" {
+		store, err = turbograb.OpenCertStore(*certstorefolder)
+		if err != nil {
+			log.Println("Error opening certificate store:", err)
+			os.Exit(1)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		outfile, err := os.Create(*output)
+		if err != nil {
+			log.Println("Error creating output file:", err)
+			os.Exit(1)
+		}
+		defer outfile.Close()
+		out = outfile
+	}
+	bw := bufio.NewWriter(out)
+	defer bw.Flush()
+
+	d := newDiscovery(sites, *scope, *limit, false, bw)
+	for _, file := range files {
+		reader, err := turbograb.OpenFile(file)
+		if err != nil {
+			log.Printf("Error opening %v: %v", file, err)
+			continue
+		}
+		if store != nil {
+			reader.UseCertStore(store)
+		}
+		for {
+			result, err := reader.Read()
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("Error reading %v: %v", file, err)
+				}
+				break
+			}
+			d.add(result.Certificates)
+		}
+		reader.Close()
+	}
+	log.Printf("Discovered %v new names", d.found)
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// JARM fingerprinting as described at https://github.com/salesforce/jarm - ten different
+// ClientHellos are sent to the server, and the choices it makes in the ServerHellos are
+// condensed into a 62 character fingerprint
+
+type jarmprobe struct {
+	recordversion, helloversion uint16
+	tls13                       bool   // probe is a TLS 1.3 probe
+	no13ciphers                 bool   // leave out the TLS 1.3 cipher suites
+	cipherorder                 string // FORWARD, REVERSE, TOP_HALF, BOTTOM_HALF or MIDDLE_OUT
+	grease                      bool
+	rarealpn                    bool
+	support                     string // 1.2_SUPPORT, 1.3_SUPPORT or NO_SUPPORT
+	extensionorder              string // FORWARD or REVERSE
+}
+
+var jarmprobes = []jarmprobe{
+	{0x0303, 0x0303, false, false, "FORWARD", false, false, "1.2_SUPPORT", "REVERSE"},
+	{0x0303, 0x0303, false, false, "REVERSE", false, false, "1.2_SUPPORT", "FORWARD"},
+	{0x0303, 0x0303, false, false, "TOP_HALF", false, false, "NO_SUPPORT", "FORWARD"},
+	{0x0303, 0x0303, false, false, "BOTTOM_HALF", false, true, "NO_SUPPORT", "FORWARD"},
+	{0x0303, 0x0303, false, false, "MIDDLE_OUT", true, true, "NO_SUPPORT", "REVERSE"},
+	{0x0302, 0x0302, false, false, "FORWARD", false, false, "NO_SUPPORT", "FORWARD"},
+	{0x0301, 0x0303, true, false, "FORWARD", false, false, "1.3_SUPPORT", "REVERSE"},
+	{0x0301, 0x0303, true, false, "REVERSE", false, false, "1.3_SUPPORT", "FORWARD"},
+	{0x0301, 0x0303, true, true, "FORWARD", false, false, "1.3_SUPPORT", "FORWARD"},
+	{0x0301, 0x0303, true, false, "MIDDLE_OUT", true, false, "1.3_SUPPORT", "REVERSE"},
+}
+
+var jarmciphers = []uint16{
+	0x0016, 0x0033, 0x0067, 0xc09e, 0xc0a2, 0x009e, 0x0039, 0x006b, 0xc09f, 0xc0a3, 0x009f, 0x0045, 0x00be, 0x0088,
+	0x00c4, 0x009a, 0xc008, 0xc009, 0xc023, 0xc0ac, 0xc0ae, 0xc02b, 0xc00a, 0xc024, 0xc0ad, 0xc0af, 0xc02c, 0xc072,
+	0xc073, 0xcca9, 0x1302, 0x1301, 0xcc14, 0xc007, 0xc012, 0xc013, 0xc027, 0xc02f, 0xc014, 0xc028, 0xc030, 0xc060,
+	0xc061, 0xc076, 0xc077, 0xcca8, 0x1305, 0x1304, 0x1303, 0xcc13, 0xc011, 0x000a, 0x002f, 0x003c, 0xc09c, 0xc0a0,
+	0x009c, 0x0035, 0x003d, 0xc09d, 0xc0a1, 0x009d, 0x0041, 0x00ba, 0x0084, 0x00c0, 0x0007, 0x0004, 0x0005,
+}
+
+// jarmcipherindex is the order ciphers are numbered in for the fuzzy part of the hash
+var jarmcipherindex = []uint16{
+	0x0004, 0x0005, 0x0007, 0x000a, 0x0016, 0x002f, 0x0033, 0x0035, 0x0039, 0x003c, 0x003d, 0x0041, 0x0045, 0x0067,
+	0x006b, 0x0084, 0x0088, 0x009a, 0x009c, 0x009d, 0x009e, 0x009f, 0x00ba, 0x00be, 0x00c0, 0x00c4, 0xc007, 0xc008,
+	0xc009, 0xc00a, 0xc011, 0xc012, 0xc013, 0xc014, 0xc023, 0xc024, 0xc027, 0xc028, 0xc02b, 0xc02c, 0xc02f, 0xc030,
+	0xc060, 0xc061, 0xc072, 0xc073, 0xc076, 0xc077, 0xc09c, 0xc09d, 0xc09e, 0xc09f, 0xc0a0, 0xc0a1, 0xc0a2, 0xc0a3,
+	0xc0ac, 0xc0ad, 0xc0ae, 0xc0af, 0xcc13, 0xcc14, 0xcca8, 0xcca9, 0x1301, 0x1302, 0x1303, 0x1304, 0x1305,
+}
+
+var jarmalpns = []string{"http/0.9", "http/1.0", "http/1.1", "spdy/1", "spdy/2", "spdy/3", "h2", "h2c", "hq"}
+var jarmrarealpns = []string{"http/0.9", "http/1.0", "spdy/1", "spdy/2", "spdy/3", "h2c", "hq"}
+
+// jarm returns the JARM fingerprint of the TLS server at addr, using host for SNI
+func jarm(d *dialer, host, addr string, timeout time.Duration) string {
+	t := &tracer{} // timings for the probes are not interesting
+	raw := make([]string, len(jarmprobes))
+	for i, probe := range jarmprobes {
+		raw[i] = "|||"
+		conn, err := d.dial(t, addr, nil)
+		if err != nil {
+			continue
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+		if _, err = conn.Write(probe.clienthello(host)); err == nil {
+			raw[i] = readserverhello(readrecord(conn))
+		}
+		conn.Close()
+	}
+	return jarmhash(raw)
+}
+
+func (p jarmprobe) clienthello(host string) []byte {
+	var hello bytes.Buffer
+	binary.Write(&hello, binary.BigEndian, p.helloversion)
+	hello.Write(randombytes(32))
+	hello.WriteByte(32) // session id
+	hello.Write(randombytes(32))
+
+	var ciphers []uint16
+	for _, cipher := range jarmciphers {
+		if p.no13ciphers && cipher>>8 == 0x13 {
+			continue
+		}
+		ciphers = append(ciphers, cipher)
+	}
+	if p.cipherorder != "FORWARD" {
+		ciphers = jarmmung(ciphers, p.cipherorder)
+	}
+	if p.grease {
+		ciphers = append([]uint16{greasevalue()}, ciphers...)
+	}
+	binary.Write(&hello, binary.BigEndian, uint16(len(ciphers)*2))
+	binary.Write(&hello, binary.BigEndian, ciphers)
+	hello.Write([]byte{0x01, 0x00}) // compression methods
+
+	extensions := p.extensions(host)
+	binary.Write(&hello, binary.BigEndian, uint16(len(extensions)))
+	hello.Write(extensions)
+
+	var packet bytes.Buffer
+	packet.WriteByte(0x16) // handshake
+	binary.Write(&packet, binary.BigEndian, p.recordversion)
+	binary.Write(&packet, binary.BigEndian, uint16(hello.Len()+4))
+	packet.WriteByte(0x01) // client hello
+	packet.WriteByte(0x00)
+	binary.Write(&packet, binary.BigEndian, uint16(hello.Len()))
+	packet.Write(hello.Bytes())
+	return packet.Bytes()
+}
+
+func (p jarmprobe) extensions(host string) []byte {
+	var ext bytes.Buffer
+	if p.grease {
+		binary.Write(&ext, binary.BigEndian, greasevalue())
+		ext.Write([]byte{0x00, 0x00})
+	}
+
+	// Server name
+	ext.Write([]byte{0x00, 0x00})
+	binary.Write(&ext, binary.BigEndian, uint16(len(host)+5))
+	binary.Write(&ext, binary.BigEndian, uint16(len(host)+3))
+	ext.WriteByte(0x00)
+	binary.Write(&ext, binary.BigEndian, uint16(len(host)))
+	ext.WriteString(host)
+
+	ext.Write([]byte{0x00, 0x17, 0x00, 0x00})                                                             // extended master secret
+	ext.Write([]byte{0x00, 0x01, 0x00, 0x01, 0x01})                                                       // max fragment length
+	ext.Write([]byte{0xff, 0x01, 0x00, 0x01, 0x00})                                                       // renegotiation info
+	ext.Write([]byte{0x00, 0x0a, 0x00, 0x0a, 0x00, 0x08, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x18, 0x00, 0x19}) // supported groups
+	ext.Write([]byte{0x00, 0x0b, 0x00, 0x02, 0x01, 0x00})                                                 // ec point formats
+	ext.Write([]byte{0x00, 0x23, 0x00, 0x00})                                                             // session ticket
+
+	// ALPN
+	alpns := jarmalpns
+	if p.rarealpn {
+		alpns = jarmrarealpns
+	}
+	if p.extensionorder != "FORWARD" {
+		alpns = jarmmung(alpns, p.extensionorder)
+	}
+	var alpnlist bytes.Buffer
+	for _, alpn := range alpns {
+		alpnlist.WriteByte(byte(len(alpn)))
+		alpnlist.WriteString(alpn)
+	}
+	ext.Write([]byte{0x00, 0x10})
+	binary.Write(&ext, binary.BigEndian, uint16(alpnlist.Len()+2))
+	binary.Write(&ext, binary.BigEndian, uint16(alpnlist.Len()))
+	ext.Write(alpnlist.Bytes())
+
+	// Signature algorithms
+	ext.Write([]byte{0x00, 0x0d, 0x00, 0x14, 0x00, 0x12, 0x04, 0x03, 0x08, 0x04, 0x04, 0x01, 0x05, 0x03, 0x08, 0x05, 0x05, 0x01, 0x08, 0x06, 0x06, 0x01, 0x02, 0x01})
+
+	// Key share
+	var share bytes.Buffer
+	if p.grease {
+		binary.Write(&share, binary.BigEndian, greasevalue())
+		share.Write([]byte{0x00, 0x01, 0x00})
+	}
+	share.Write([]byte{0x00, 0x1d, 0x00, 0x20})
+	share.Write(randombytes(32))
+	ext.Write([]byte{0x00, 0x33})
+	binary.Write(&ext, binary.BigEndian, uint16(share.Len()+2))
+	binary.Write(&ext, binary.BigEndian, uint16(share.Len()))
+	ext.Write(share.Bytes())
+
+	ext.Write([]byte{0x00, 0x2d, 0x00, 0x02, 0x01, 0x01}) // psk key exchange modes
+
+	// Supported versions
+	if p.tls13 || p.support == "1.2_SUPPORT" {
+		versions := []uint16{0x0301, 0x0302, 0x0303}
+		if p.support != "1.2_SUPPORT" {
+			versions = append(versions, 0x0304)
+		}
+		if p.extensionorder != "FORWARD" {
+			versions = jarmmung(versions, p.extensionorder)
+		}
+		if p.grease {
+			versions = append([]uint16{greasevalue()}, versions...)
+		}
+		ext.Write([]byte{0x00, 0x2b})
+		binary.Write(&ext, binary.BigEndian, uint16(len(versions)*2+1))
+		ext.WriteByte(byte(len(versions) * 2))
+		binary.Write(&ext, binary.BigEndian, versions)
+	}
+
+	return ext.Bytes()
+}
+
+// jarmmung reorders items the way JARM does it
+func jarmmung[T any](items []T, order string) []T {
+	var output []T
+	n := len(items)
+	switch order {
+	case "REVERSE":
+		for i := n - 1; i >= 0; i-- {
+			output = append(output, items[i])
+		}
+	case "BOTTOM_HALF":
+		if n%2 == 1 {
+			output = append(output, items[n/2+1:]...)
+		} else {
+			output = append(output, items[n/2:]...)
+		}
+	case "TOP_HALF":
+		// Top half in reverse order, and it gets the middle one if there is one
+		if n%2 == 1 {
+			output = append(output, items[n/2])
+		}
+		output = append(output, jarmmung(jarmmung(items, "REVERSE"), "BOTTOM_HALF")...)
+	case "MIDDLE_OUT":
+		middle := n / 2
+		if n%2 == 1 {
+			output = append(output, items[middle])
+			for i := 1; i <= middle; i++ {
+				output = append(output, items[middle+i], items[middle-i])
+			}
+		} else {
+			for i := 1; i <= middle; i++ {
+				output = append(output, items[middle-1+i], items[middle-i])
+			}
+		}
+	}
+	return output
+}
+
+// readrecord reads up to the end of the first TLS record the server sends
+func readrecord(conn net.Conn) []byte {
+	buffer := make([]byte, 1484)
+	var n int
+	for n < len(buffer) {
+		read, err := conn.Read(buffer[n:])
+		n += read
+		if n >= 5 && n >= 5+int(binary.BigEndian.Uint16(buffer[3:5])) {
+			break
+		}
+		if err != nil {
+			break
+		}
+	}
+	return buffer[:n]
+}
+
+// readserverhello returns the cipher|version|alpn|extensions string for a probe answer
+func readserverhello(data []byte) (result string) {
+	defer func() {
+		if recover() != nil {
+			result = "|||"
+		}
+	}()
+
+	if len(data) < 6 || data[0] != 0x16 || data[5] != 0x02 {
+		// Alert, or no server hello
+		return "|||"
+	}
+
+	hellolength := int(binary.BigEndian.Uint16(data[3:5]))
+	counter := int(data[43])
+	cipher := hex.EncodeToString(data[counter+44 : counter+46])
+	version := hex.EncodeToString(data[9:11])
+	return cipher + "|" + version + "|" + jarmextensions(data, counter, hellolength)
+}
+
+func jarmextensions(data []byte, counter, hellolength int) (result string) {
+	defer func() {
+		if recover() != nil {
+			result = "|"
+		}
+	}()
+
+	if data[counter+47] == 11 {
+		return "|"
+	} else if bytes.Equal(clampslice(data, counter+50, counter+53), []byte{0x0e, 0xac, 0x0b}) || bytes.Equal(clampslice(data, 82, 85), []byte{0x0f, 0xf0, 0x0b}) {
+		return "|"
+	} else if counter+42 >= hellolength {
+		return "|"
+	}
+
+	count := 49 + counter
+	length := int(binary.BigEndian.Uint16(data[counter+47 : counter+49]))
+	maximum := length + count - 1
+
+	var types []string
+	var alpn string
+	for count < maximum {
+		exttype := data[count : count+2]
+		extlength := int(binary.BigEndian.Uint16(data[count+2 : count+4]))
+		var value []byte
+		if extlength > 0 {
+			value = data[count+4 : count+4+extlength]
+		}
+		if alpn == "" && bytes.Equal(exttype, []byte{0x00, 0x10}) && len(value) > 3 {
+			alpn = string(value[3:])
+		}
+		types = append(types, hex.EncodeToString(exttype))
+		count += extlength + 4
+	}
+	return alpn + "|" + strings.Join(types, "-")
+}
+
+// jarmhash turns the raw answers into the fingerprint
+func jarmhash(raw []string) string {
+	empty := true
+	for _, answer := range raw {
+		if answer != "|||" {
+			empty = false
+		}
+	}
+	if empty {
+		return strings.Repeat("0", 62)
+	}
+
+	var fuzzy strings.Builder
+	var alpnsandextensions strings.Builder
+	for _, answer := range raw {
+		components := strings.Split(answer, "|")
+		fuzzy.WriteString(jarmcipherbyte(components[0]))
+		fuzzy.WriteString(jarmversionbyte(components[1]))
+		alpnsandextensions.WriteString(components[2])
+		alpnsandextensions.WriteString(components[3])
+	}
+	sum := sha256.Sum256([]byte(alpnsandextensions.String()))
+	return fuzzy.String() + hex.EncodeToString(sum[:])[:32]
+}
+
+func jarmcipherbyte(cipher string) string {
+	if cipher == "" {
+		return "00"
+	}
+	count := 1
+	for _, c := range jarmcipherindex {
+		if cipher == fmt.Sprintf("%04x", c) {
+			break
+		}
+		count++
+	}
+	return fmt.Sprintf("%02x", count)
+}
+
+func jarmversionbyte(version string) string {
+	if len(version) < 4 {
+		return "0"
+	}
+	minor := int(version[3] - '0')
+	if minor < 0 || minor > 5 {
+		return "0"
+	}
+	return string("abcdef"[minor])
+}
+
+// clampslice slices like Python does, going past the end just returns less
+func clampslice(data []byte, from, to int) []byte {
+	if to > len(data) {
+		to = len(data)
+	}
+	if from > to {
+		from = to
+	}
+	return data[from:to]
+}
+
+func greasevalue() uint16 {
+	g := uint16(mathrand.Intn(16))<<4 | 0x0a
+	return g<<8 | g
+}
+
+func randombytes(n int) []byte {
+	b := make([]byte, n)
+	io.ReadFull(rand.Reader, b)
+	return b
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJarmClienthello(t *testing.T) {
+	for i, probe := range jarmprobes {
+		hello := probe.clienthello("example.com")
+		if len(hello) < 9 || hello[0] != 0x16 || hello[5] != 0x01 {
+			t.Fatalf("probe %v: not a handshake record with a client hello", i)
+		}
+		if binary.BigEndian.Uint16(hello[1:3]) != probe.recordversion {
+			t.Errorf("probe %v: record version %04x", i, binary.BigEndian.Uint16(hello[1:3]))
+		}
+		if recordlength := int(binary.BigEndian.Uint16(hello[3:5])); recordlength != len(hello)-5 {
+			t.Errorf("probe %v: record length %v, want %v", i, recordlength, len(hello)-5)
+		}
+		if hellolength := int(hello[6])<<16 | int(binary.BigEndian.Uint16(hello[7:9])); hellolength != len(hello)-9 {
+			t.Errorf("probe %v: hello length %v, want %v", i, hellolength, len(hello)-9)
+		}
+		if !strings.Contains(string(hello), "example.com") {
+			t.Errorf("probe %v: no SNI", i)
+		}
+	}
+}
+
+// jarmserver starts a TLS server with config and returns the raw probe answers from it
+func jarmserver(t *testing.T, config *tls.Config) []string {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = config
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // the probes make plenty of failed handshakes
+	server.StartTLS()
+	defer server.Close()
+
+	addr := server.Listener.Addr().String()
+	raw := make([]string, len(jarmprobes))
+	for i, probe := range jarmprobes {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err = conn.Write(probe.clienthello("example.com")); err != nil {
+			t.Fatal(err)
+		}
+		raw[i] = readserverhello(readrecord(conn))
+		conn.Close()
+	}
+	return raw
+}
+
+func TestJarmServers(t *testing.T) {
+	tls12 := jarmserver(t, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	})
+	tls13 := jarmserver(t, &tls.Config{
+		MinVersion: tls.VersionTLS13,
+	})
+
+	// The first probe offers TLS 1.2 only
+	if tls12[0] == "|||" || !strings.HasPrefix(tls12[0], "c02f|0303|") {
+		t.Errorf("TLS 1.2 server answered %q to a TLS 1.2 probe", tls12[0])
+	}
+	if tls13[0] != "|||" {
+		t.Errorf("TLS 1.3 only server answered %q to a TLS 1.2 probe", tls13[0])
+	}
+	// The seventh probe offers TLS 1.3, which the server tells in supported_versions and not the hello version
+	if !strings.HasPrefix(tls13[6], "1301|0303|") && !strings.HasPrefix(tls13[6], "1302|0303|") && !strings.HasPrefix(tls13[6], "1303|0303|") {
+		t.Errorf("TLS 1.3 server answered %q to a TLS 1.3 probe", tls13[6])
+	}
+	if !strings.Contains(tls13[6], "002b") {
+		t.Errorf("TLS 1.3 answer %q has no supported_versions extension", tls13[6])
+	}
+	// TLS 1.1 is not allowed by either
+	if tls12[5] != "|||" || tls13[5] != "|||" {
+		t.Errorf("TLS 1.1 probe got answers %q and %q", tls12[5], tls13[5])
+	}
+
+	hash12, hash13 := jarmhash(tls12), jarmhash(tls13)
+	if len(hash12) != 62 || len(hash13) != 62 {
+		t.Fatalf("hash lengths %v and %v", len(hash12), len(hash13))
+	}
+	if hash12 == hash13 {
+		t.Errorf("different servers got the same fingerprint %v", hash12)
+	}
+	if again := jarmhash(jarmserver(t, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	})); again != hash12 {
+		t.Errorf("same server got fingerprints %v and %v", hash12, again)
+	}
+}
+
+func TestJarmReadserverhello(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		{0x15, 0x03, 0x03, 0x00, 0x02, 0x02, 0x28}, // handshake failure alert
+		{0x16, 0x03, 0x03, 0x00, 0x40, 0x02},       // truncated server hello
+	} {
+		if result := readserverhello(data); result != "|||" {
+			t.Errorf("readserverhello(%x) = %q", data, result)
+		}
+	}
+}
+
+func TestJarmhash(t *testing.T) {
+	empty := make([]string, len(jarmprobes))
+	for i := range empty {
+		empty[i] = "|||"
+	}
+	if hash := jarmhash(empty); hash != strings.Repeat("0", 62) {
+		t.Errorf("no answers gave %v", hash)
+	}
+
+	raw := append([]string{}, empty...)
+	raw[0] = "c02f|0303|h2|0000-0017"
+	hash := jarmhash(raw)
+	if len(hash) != 62 {
+		t.Fatalf("hash length %v", len(hash))
+	}
+	// c02f is number 41 in the cipher index, and 0303 is TLS 1.2
+	if !strings.HasPrefix(hash, "29d000") {
+		t.Errorf("hash %v doesn't start with the first answer", hash)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,8 +13,10 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"crypto/tls"
@@ -31,19 +34,75 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
+// batchsize is how much of a site's results a producer holds before passing them on to the writers
+const batchsize = 8 * 1024 * 1024
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "certstore":
+			certstorecommand(os.Args[2:])
+			return
+		case "discover":
+			discovercommand(os.Args[2:])
+			return
+		case "certreport":
+			certreportcommand(os.Args[2:])
+			return
+		case "technologies":
+			technologiescommand(os.Args[2:])
+			return
+		}
+	}
+
 	// Grabbing data
 	sitelist := pflag.String("sitelist", "", "File to read sites from (plain text) or comma separated list")
+	mode := pflag.String("mode", "http", "Grabbing mode (http, tls, tlsenum, starttls, banner)")
+	starttlsprotocol := pflag.String("starttls", "smtp", "Protocol to use in starttls mode (smtp, submission, imap, pop3, ftp, xmpp)")
+	port := pflag.Int("port", 0, "Port to connect to in banner mode, for sites that don't have one")
+	probe := pflag.String("probe", "", "Data to send in banner mode before reading, Go escapes like \\r\\n are allowed")
+	bannerwait := pflag.Duration("bannerwait", 2*time.Second, "Stop reading in banner mode when nothing has been received for this long")
+	discover := pflag.Bool("discover", false, "Also grab host names found in certificates, if they are in scope")
+	discoveredfile := pflag.String("discovered", "", "File to write host names found in certificates to, as a new sitelist")
+	scope := pflag.StringSlice("scope", nil, "Domains discovered host names must be in or below (default is the registrable domains in the sitelist)")
+	maxdiscovered := pflag.Int("maxdiscovered", 100000, "Max number of host names to discover")
+	jarmenable := pflag.Bool("jarm", false, "Probe each site that answered for its JARM TLS fingerprint")
 
 	urlpaths := pflag.StringSlice("urlpath", []string{"/"}, "Path to grab")
+	allpaths := pflag.Bool("allpaths", false, "Grab every --urlpath with one result for each, instead of trying them in order until one works")
+	wordlist := pflag.String("wordlist", "", "File with paths to discover, they are only stored if they don't look like the site's answer for a path that doesn't exist, implies --allpaths")
+	simhashdistance := pflag.Int("simhashdistance", 8, "Pages within this many bits of simhash from the not found page count as not found")
+	bundlenames := pflag.StringSlice("bundle", nil, "Grab bundles of well known paths and check they are really there (wellknown, admin, vcs), implies --allpaths")
+	depth := pflag.Int("depth", 0, "Follow links on grabbed pages this many levels deep, each page is stored as its own result (0 means don't crawl)")
+	maxpages := pflag.Int("maxpages", 100, "Max number of pages to grab from each site when crawling")
+	sitemap := pflag.Bool("sitemap", false, "Also grab the pages listed in the site's sitemaps, from robots.txt and /sitemap.xml")
+	maxsitemapurls := pflag.Int("maxsitemapurls", 1000, "Max number of pages to take from sitemaps for each site")
+	crawldomain := pflag.Bool("crawldomain", false, "Also follow links to other hosts in the registrable domain of the site when crawling")
+	metadata := pflag.Bool("metadata", false, "Parse web pages and store their title, description, generator, links and other metadata")
+	technologies := pflag.Bool("technologies", false, "Detect the web servers, frameworks and other technologies sites run")
+	techrulesfile := pflag.String("techrules", "", "Technology rules file for --technologies (default is the built in rules)")
 	storecodes := pflag.IntSlice("storecodes", nil, "Return codes to store data from (default blank, means save all)")
 	parallel := pflag.Int("parallel", runtime.NumCPU()*32, "Number of parallel requests")
+	adaptiveenable := pflag.Bool("adaptive", false, "Adjust number of parallel requests based on timeouts, connection errors and throughput, using --parallel as upper limit")
+	minparallel := pflag.Int("minparallel", 16, "Lower limit for number of parallel requests in adaptive mode")
+	congestionrate := pflag.Float64("congestion", 0.1, "Rate of timeouts and connection errors that makes adaptive mode back off")
 	timeout := pflag.Int("timeout", 15, "Timeout after seconds")
 	maxretries := pflag.Int("retries", 10, "Max number of retries")
 	maxredirects := pflag.Int("redirects", 5, "Max number of redirects")
 	maxresponsesize := pflag.Int("maxresponsesize", 32*1024*1024, "Max response size in bytes")
 	useragent := pflag.String("useragent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/107.0.0.0 Safari/537.36 Edg/107.0.1418.56", "User agent to send to server")
 	showerrors := pflag.Bool("showerrors", false, "Show errors")
+	backoffinitial := pflag.Duration("backoff", time.Second, "Initial delay between retries")
+	backoffmax := pflag.Duration("maxbackoff", 30*time.Second, "Max delay between retries, also the longest Retry-After we will honour")
+	backofffactor := pflag.Float64("backofffactor", 2, "Multiply delay between retries by this for each retry")
+	backoffjitter := pflag.Float64("backoffjitter", 0.2, "Randomize delay between retries by up to this fraction")
+
+	// Politeness
+	maxperip := pflag.Int("maxperip", 0, "Max concurrent connections per IP address (0 means unlimited)")
+	maxperdomain := pflag.Int("maxperdomain", 0, "Max concurrent connections per registrable domain (0 means unlimited)")
+	rateperip := pflag.Float64("rateperip", 0, "Max requests per second per IP address (0 means unlimited)")
+	rateperdomain := pflag.Float64("rateperdomain", 0, "Max requests per second per registrable domain (0 means unlimited)")
+	respectrobots := pflag.Bool("respect-robots", false, "Fetch robots.txt for each host, skip paths it disallows for --useragent and wait for its Crawl-delay between requests")
 
 	// Saving data
 	outputfolder := pflag.String("outputfolder", "", "Results output folder name (if blank will use one file per site scanned)")
@@ -51,6 +110,14 @@ func main() {
 	compression := pflag.Bool("compress", false, "Store LZ4 compressed")
 	recordsperfile := pflag.Int("perfile", 10000, "Number of records in each file")
 	buckets := pflag.Int("buckets", 4096, "Number of buckets to place files in")
+	clientcert := pflag.String("client-cert", "", "PEM file with client certificate to present to servers that ask for one")
+	clientkey := pflag.String("client-key", "", "PEM file with private key for --client-cert")
+	sni := pflag.String("sni", "host", "Server name to send in TLS handshake (none, host, custom)")
+	sniname := pflag.String("sni-name", "", "Server name to send when --sni=custom")
+	tlsmin := pflag.String("tls-min", "", "Lowest TLS version to offer (1.0, 1.1, 1.2, 1.3)")
+	tlsmax := pflag.String("tls-max", "", "Highest TLS version to offer (1.0, 1.1, 1.2, 1.3)")
+	certstorefolder := pflag.String("certstore", "", "Folder to write each certificate to once, results then refer to them by SHA-256 fingerprint")
+	cabundle := pflag.String("ca-bundle", "", "PEM file with extra CA certificates to validate certificate chains against")
 	skipnewerthan := pflag.Int("skipnewerthan", 7*1440, "Skip existing files that are newer than N minutes, only works with perfile=1")
 
 	// Debugging
@@ -87,8 +154,139 @@ func main() {
 		}
 	}()
 
+	switch *mode {
+	case "http", "tls", "tlsenum":
+	case "starttls":
+		if _, found := starttlsprotocols[*starttlsprotocol]; !found {
+			log.Println("Unknown STARTTLS protocol", *starttlsprotocol)
+			os.Exit(1)
+		}
+	case "banner":
+	default:
+		log.Println("Unknown mode", *mode)
+		os.Exit(1)
+	}
+
+	// Validators for the paths in bundles, so soft 404s aren't reported as hits
+	validators := make(map[string]func(bundleresponse) bool)
+	if len(*bundlenames) > 0 {
+		var paths []string
+		if pflag.CommandLine.Changed("urlpath") {
+			paths = append(paths, *urlpaths...)
+		}
+		for _, name := range *bundlenames {
+			bundle, found := bundles[name]
+			if !found {
+				log.Println("Unknown bundle", name)
+				os.Exit(1)
+			}
+			for _, bp := range bundle {
+				paths = append(paths, bp.path)
+				validators[bp.path] = bp.valid
+			}
+		}
+		*urlpaths = paths
+		*allpaths = true
+	}
+
+	wordpaths := make(map[string]struct{})
+	if *wordlist != "" {
+		rawwords, err := os.ReadFile(*wordlist)
+		if err != nil {
+			log.Println("Error reading wordlist:", err)
+			os.Exit(1)
+		}
+		var paths []string
+		if len(*bundlenames) > 0 || pflag.CommandLine.Changed("urlpath") {
+			paths = append(paths, *urlpaths...)
+		}
+		for _, word := range strings.Split(string(rawwords), "\n") {
+			word = strings.TrimSpace(word)
+			if word == "" || strings.HasPrefix(word, "#") {
+				continue
+			}
+			if !strings.HasPrefix(word, "/") {
+				word = "/" + word
+			}
+			if _, found := wordpaths[word]; !found {
+				wordpaths[word] = struct{}{}
+				paths = append(paths, word)
+			}
+		}
+		*urlpaths = paths
+		*allpaths = true
+	}
+
+	probedata, err := strconv.Unquote(`"` + strings.ReplaceAll(*probe, `"`, `\"`) + `"`)
+	if err != nil {
+		log.Println("Invalid probe:", err.Error())
+		os.Exit(1)
+	}
+	var bannerport string
+	if *port != 0 {
+		bannerport = strconv.Itoa(*port)
+	}
+
 	timeoutDuration := time.Second * time.Duration(*timeout)
 
+	certvalidator, err := newValidator(*cabundle)
+	if err != nil {
+		log.Printf("Error loading CA bundle %v: %v", *cabundle, err)
+		os.Exit(1)
+	}
+
+	var certstore *turbograb.CertStore
+	if *certstorefolder != "" {
+		certstore, err = turbograb.OpenCertStore(*certstorefolder)
+		if err != nil {
+			log.Printf("Error opening certificate store %v: %v", *certstorefolder, err)
+			os.Exit(1)
+		}
+	}
+
+	var clientcerts []tls.Certificate
+	if *clientcert != "" || *clientkey != "" {
+		if *clientkey == "" {
+			*clientkey = *clientcert // key might be in the same file
+		}
+		cert, err := tls.LoadX509KeyPair(*clientcert, *clientkey)
+		if err != nil {
+			log.Printf("Error loading client certificate %v: %v", *clientcert, err)
+			os.Exit(1)
+		}
+		clientcerts = append(clientcerts, cert)
+	}
+
+	switch *sni {
+	case "none", "host":
+	case "custom":
+		if *sniname == "" {
+			log.Println("--sni=custom needs --sni-name")
+			os.Exit(1)
+		}
+	default:
+		log.Println("Unknown SNI option", *sni)
+		os.Exit(1)
+	}
+
+	minversion, err := tlsversion(*tlsmin)
+	if err != nil {
+		log.Println(err.Error())
+		os.Exit(1)
+	}
+	maxversion, err := tlsversion(*tlsmax)
+	if err != nil {
+		log.Println(err.Error())
+		os.Exit(1)
+	}
+
+	policy := backoff{
+		initial: *backoffinitial,
+		max:     *backoffmax,
+		factor:  *backofffactor,
+		jitter:  *backoffjitter,
+	}
+
 	var codes map[int]struct{}
 	if len(*storecodes) > 0 {
 		codes = make(map[int]struct{})
@@ -118,6 +316,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	var discoverer *discovery
+	if *discover || *discoveredfile != "" {
+		var output io.Writer
+		if *discoveredfile != "" {
+			discovered, err := os.Create(*discoveredfile)
+			if err != nil {
+				log.Println("Error creating discovered sitelist:", err)
+				os.Exit(1)
+			}
+			defer discovered.Close()
+			output = discovered
+		}
+		discoverer = newDiscovery(sites, *scope, *maxdiscovered, *discover, output)
+	}
+
 	var producerWG, writerWG sync.WaitGroup
 	producerQueue := make(chan string, *parallel*4)
 
@@ -134,298 +347,708 @@ func main() {
 
 	fasthttp.SetBodySizePoolLimit(65536, 65536)
 
+	var stats timingstats
+	sharedDialer := &dialer{
+		timeout: timeoutDuration,
+		limits:  newPoliteness(*maxperip, *maxperdomain, *rateperip, *rateperdomain),
+		sni:     *sni,
+		sniname: *sniname,
+	}
+
+	var techrules *turbograb.TechRules
+	if *technologies {
+		techrules = loadtechrules(*techrulesfile)
+	}
+
+	var robots *robotscache
+	if *respectrobots {
+		robots = newRobotscache()
+	}
+
+	var throttle *adaptive
+	if *adaptiveenable {
+		throttle = newAdaptive(*minparallel, *parallel, *congestionrate)
+		pb.Describe(fmt.Sprintf("parallel %v", throttle.current()))
+		go throttle.run(time.Second*5, func(limit int) {
+			pb.Describe(fmt.Sprintf("parallel %v", limit))
+		})
+	}
+
 	// Producers
 	for i := 0; i < *parallel; i++ {
 		producerWG.Add(1)
-		go func() {
+		go func(i int) {
+			// We only speak HTTP/1.1, but in TLS mode we want to see if the server prefers HTTP/2
+			alpn := []string{"http/1.1"}
+			if *mode != "http" {
+				alpn = []string{"h2", "http/1.1"}
+			}
+
+			// Reconnects to a site, like after a redirect or a retry, can resume the TLS session
 			var certinfo []*x509.Certificate
+			sessioncache := tls.NewLRUClientSessionCache(0)
 			securetls := &tls.Config{
+				ClientSessionCache:    sessioncache,
+				NextProtos:            alpn,
 				VerifyPeerCertificate: storecertinfo(&certinfo),
+				Certificates:          clientcerts,
+				MinVersion:            minversion,
+				MaxVersion:            maxversion,
 			}
 			insecuretls := &tls.Config{
 				InsecureSkipVerify:    true,
+				ClientSessionCache:    sessioncache,
+				NextProtos:            alpn,
 				VerifyPeerCertificate: storecertinfo(&certinfo),
+				Certificates:          clientcerts,
+				MinVersion:            minversion,
+				MaxVersion:            maxversion,
+			}
+
+			trace := &tracer{}
+			sess := newSession(sharedDialer, trace, timeoutDuration, *maxresponsesize)
+			sess.robots = robots
+			tlsonly := &tlsgrabber{
+				dialer:     sharedDialer,
+				trace:      trace,
+				throttle:   throttle,
+				policy:     policy,
+				config:     insecuretls,
+				validator:  certvalidator,
+				retries:    *maxretries,
+				showerrors: *showerrors,
+				jarm:       *jarmenable,
+				timeout:    timeoutDuration,
+			}
+			bannergrab := &bannergrabber{
+				dialer:     sharedDialer,
+				trace:      trace,
+				throttle:   throttle,
+				policy:     policy,
+				timeout:    timeoutDuration,
+				idle:       *bannerwait,
+				port:       bannerport,
+				probe:      []byte(probedata),
+				maxsize:    *maxresponsesize,
+				retries:    *maxretries,
+				showerrors: *showerrors,
+			}
+
+			// Extra files like robots.txt and sitemaps don't need verifying, and mustn't replace the certificates we store
+			extratls := &tls.Config{
+				InsecureSkipVerify: true,
+				ClientSessionCache: sessioncache,
+				NextProtos:         alpn,
+				Certificates:       clientcerts,
+				MinVersion:         minversion,
+				MaxVersion:         maxversion,
+			}
+
+			// With --allpaths, crawling or sitemaps the results for a site are sent together, so they end up in the same file
+			// and a file is never named after the same site twice. Sites with more than batchsize go in parts.
+			var batch []byte
+			var batchrecords, batchpart int
+			batching := (*allpaths || *depth > 0 || *sitemap) && *mode == "http"
+			flush := func(site string) {
+				if batchrecords == 0 {
+					return
+				}
+				encodedQueue <- turbograb.Encoded{
+					Site:    site,
+					Part:    batchpart,
+					Records: batchrecords,
+					Data:    batch,
+				}
+				batch = nil
+				batchrecords = 0
+				batchpart++
+			}
+
+			emit := func(result turbograb.Result) {
+				stats.add(result.Timings)
+				discoverer.add(result.Certificates)
+
+				if certstore != nil && len(result.Certificates) > 0 {
+					refs := make([]string, 0, len(result.Certificates))
+					for _, cert := range result.Certificates {
+						fingerprint, err := certstore.Put(cert)
+						if err != nil {
+							log.Printf("Error writing certificate to store: %v", err)
+							break
+						}
+						refs = append(refs, fingerprint)
+					}
+					// Keep the certificates in the result if any of them couldn't be stored
+					if len(refs) == len(result.Certificates) {
+						result.CertificateRefs = refs
+						result.Certificates = nil
+					}
+				}
+
+				var jd []byte
+				switch *format {
+				case "json":
+					jd = generateJSON(result)
+				case "txt":
+					jd = generateTXT(result)
+				default:
+					log.Println("Unknown format", *format)
+					os.Exit(1)
+				}
+				if batching {
+					batch = append(batch, jd...)
+					batchrecords++
+					if len(batch) >= batchsize {
+						flush(result.Site)
+					}
+					return
+				}
+				encodedQueue <- turbograb.Encoded{
+					Site:    result.Site,
+					Records: 1,
+					Data:    jd,
+				}
 			}
 
-			var hostclient *fasthttp.HostClient
 			var req *fasthttp.Request
 			var resp *fasthttp.Response
 			var requestshandled int
 
-			for site := range producerQueue {
+			var grabbing bool
+			for {
+				if grabbing {
+					discoverer.done()
+				}
+				throttle.wait(i)
+				site, ok := <-producerQueue
+				if !ok {
+					break
+				}
+				grabbing = true
+
 				var siteerr error
 				certinfo = nil
+				trace.reset()
 
 				if *recordsperfile == 1 && *skipnewerthan > 0 {
-					if stat, err := os.Stat(generateFilename(*outputfolder, site, *recordsperfile, *buckets, *format, *compression)); err == nil {
+					if stat, err := os.Stat(generateFilename(*outputfolder, site, 0, *recordsperfile, *buckets, *format, *compression)); err == nil {
 						if time.Since(stat.ModTime()) < time.Minute*time.Duration(*skipnewerthan) {
 							continue
 						}
 					}
 				}
 
-				retriesleft := *maxretries
-				redirectsleft := *maxredirects
-				var code int
-				var ipaddress, body, header, errstring string
+				if *mode != "http" {
+					var result turbograb.Result
+					switch *mode {
+					case "tlsenum":
+						result = tlsonly.enumerate(site, &certinfo)
+					case "starttls":
+						result = tlsonly.starttls(site, *starttlsprotocol, &certinfo)
+					case "banner":
+						result = bannergrab.grab(site)
+					default:
+						result = tlsonly.grab(site, &certinfo)
+					}
+					slices.Sort(result.Warnings)
+					result.Warnings = slices.Compact(result.Warnings)
+					result.Timings = trace.result()
+					emit(result)
+					continue
+				}
 
-				if hostclient != nil && hostclient.ConnsCount() > 0 {
-					hostclient.CloseIdleConnections()
-					time.Sleep(time.Second * 2) // Wait for background cleanup goroutine to finish, sic
+				// Each entry is a list of paths to try until one works, normally there's just the one list
+				pathlists := []pathlist{{paths: *urlpaths}}
+				if *allpaths {
+					pathlists = nil
+					for _, urlpath := range *urlpaths {
+						pathlists = append(pathlists, pathlist{paths: []string{urlpath}})
+					}
 				}
 
-				protocol := "https"
-				hostclient = &fasthttp.HostClient{
-					IsTLS:                    true,
-					TLSConfig:                securetls,
-					NoDefaultUserAgentHeader: true,
-					MaxResponseBodySize:      *maxresponsesize,
-					WriteTimeout:             timeoutDuration,
-					ReadTimeout:              timeoutDuration,
-					MaxConns:                 1,
-					MaxIdleConnDuration:      time.Millisecond * 1100, // A tiny amount more than the sleep interval
+				// Learn what the site says for a path that doesn't exist before trying the wordlist
+				probing := len(wordpaths) > 0
+				if probing {
+					pathlists = append([]pathlist{{paths: []string{randompath()}}}, pathlists...)
 				}
-				urlpathindex := 0
-				urlpath := (*urlpaths)[urlpathindex]
-
-				closerequest := true
-				justnotcloserequest := false
-
-				var warnings []string
-				var siteurl string
-				host := site
-			retryloop:
-				for retriesleft > 0 {
-					if protocol == "https" {
-						hostclient.IsTLS = true
-						hostclient.Addr = host + ":443"
-					} else if protocol == "http" {
-						hostclient.IsTLS = false
-						hostclient.Addr = host + ":80"
+				sitecrawl := newCrawl(site, *depth, *maxpages, *crawldomain)
+				var sitemapdone, favicondone bool
+				var sitenotfound *notfound
+				var probefailed bool
+
+				// What we learn about the site on one path is used for the rest
+				sitehost := site
+				siteprotocol := "https"
+				sitetls := securetls
+				var jarmhash string
+
+				// Crawling adds pages to the end as it goes
+				for pathno := 0; pathno < len(pathlists); pathno++ {
+					fallbackpaths := pathlists[pathno].paths
+					_, isword := wordpaths[fallbackpaths[0]]
+					if isword && probefailed {
+						continue
 					}
 
-					if !strings.HasPrefix(urlpath, "/") {
-						urlpath = "/" + urlpath
+					retriesleft := *maxretries
+					redirectsleft := *maxredirects
+					var code int
+					var ipaddress, body, header, errstring string
+
+					protocol := siteprotocol
+					tlsconfig := sitetls
+					urlpathindex := 0
+					urlpath := fallbackpaths[urlpathindex]
+
+					// Keep the connection open for the next path
+					closerequest := !*allpaths && sitecrawl == nil && !*sitemap
+					justnotcloserequest := false
+
+					var warnings []string
+					var siteurl string
+					host := sitehost
+					if pathlists[pathno].host != "" {
+						host = pathlists[pathno].host
+						protocol = pathlists[pathno].protocol
 					}
 
-					uri := fasthttp.AcquireURI()
-					siteerr = uri.Parse(nil, []byte(protocol+"://"+host+urlpath))
-					if siteerr != nil {
-						break retryloop
+					var robotsdecision string
+					if robots != nil {
+						rh := robots.host(protocol+"://"+host, func() *robotsrules {
+							return fetchrobots(sess, protocol, host, extratls, *useragent, *maxredirects)
+						})
+						fallbackpaths = slices.DeleteFunc(slices.Clone(fallbackpaths), func(p string) bool {
+							return !rh.allowed(p)
+						})
+						if len(fallbackpaths) == 0 || rh.delay() > policy.max {
+							if probing && pathno == 0 {
+								probefailed = true
+								continue
+							}
+							if len(fallbackpaths) > 0 {
+								warnings = append(warnings, "robots_crawl_delay_too_long")
+							} else {
+								fallbackpaths = pathlists[pathno].paths
+							}
+							trace.reset()
+							emit(turbograb.Result{
+								Site:     site,
+								URL:      protocol + "://" + host + fallbackpaths[0],
+								Warnings: warnings,
+								Timings:  trace.result(),
+								Robots:   "disallowed",
+								Depth:    pathlists[pathno].depth,
+							})
+							continue
+						}
+						robotsdecision = "allowed"
+						urlpath = fallbackpaths[urlpathindex]
 					}
-
-					if req != nil {
-						fasthttp.ReleaseRequest(req)
-						fasthttp.ReleaseResponse(resp)
+					if pathno > 0 || robots != nil {
+						trace.reset()
 					}
+				retryloop:
+					for retriesleft > 0 {
+						var addr string
+						var conntls *tls.Config
+						if protocol == "https" {
+							addr = host + ":443"
+							conntls = tlsconfig
+						} else if protocol == "http" {
+							addr = host + ":80"
+						}
+						if _, port, err := net.SplitHostPort(host); err == nil && port != "" {
+							// Crawled links and sitemap pages can be on another port
+							addr = host
+						}
 
-					req = fasthttp.AcquireRequest()
-					resp = fasthttp.AcquireResponse()
-
-					if closerequest {
-						req.SetConnectionClose()
-						resp.SetConnectionClose()
-					}
+						if !strings.HasPrefix(urlpath, "/") {
+							urlpath = "/" + urlpath
+						}
 
-					req.Header.SetUserAgent(*useragent)
-					req.SetURI(uri)
-					fasthttp.ReleaseURI(uri)
+						siteurl = protocol + "://" + host + urlpath
 
-					siteerr = hostclient.DoTimeout(req, resp, timeoutDuration)
+						// Every request waits for Crawl-delay, and redirects and www. can lead to a host with other rules
+						if robots != nil {
+							rh := robots.host(protocol+"://"+host, func() *robotsrules {
+								return fetchrobots(sess, protocol, host, extratls, *useragent, *maxredirects)
+							})
+							if !rh.allowed(urlpath) || rh.delay() > policy.max {
+								if rh.delay() > policy.max {
+									warnings = append(warnings, "robots_crawl_delay_too_long")
+								}
+								robotsdecision = "disallowed"
+								siteerr = errRobotsDisallowed
+								break retryloop
+							}
+							if rh.wait() > 0 {
+								robotsdecision = "delayed"
+							}
+						}
 
-					requestshandled++
+						uri := fasthttp.AcquireURI()
+						siteerr = uri.Parse(nil, []byte(siteurl))
+						if siteerr != nil {
+							break retryloop
+						}
 
-					ipaddress = resp.RemoteAddr().String()
+						if req != nil {
+							fasthttp.ReleaseRequest(req)
+							fasthttp.ReleaseResponse(resp)
+						}
 
-					if siteerr == nil {
-						code = resp.Header.StatusCode()
+						req = fasthttp.AcquireRequest()
+						resp = fasthttp.AcquireResponse()
 
-						if code == 200 || code == 206 {
-							body = string(resp.Body())
-							header = resp.Header.String()
-							errstring = ""
-							break retryloop
+						if closerequest {
+							req.SetConnectionClose()
+							resp.SetConnectionClose()
 						}
 
-						if fasthttp.StatusCodeIsRedirect(code) {
-							warnings = append(warnings, "redirect")
+						req.Header.SetUserAgent(*useragent)
+						req.SetURI(uri)
+						fasthttp.ReleaseURI(uri)
 
-							redirectsleft--
-							if redirectsleft == 0 {
-								siteerr = fasthttp.ErrTooManyRedirects
-								break retryloop
-							}
+						wait := policy.delay(*maxretries - retriesleft)
 
-							newlocation := resp.Header.Peek("Location")
-							if len(newlocation) == 0 {
-								siteerr = fasthttp.ErrMissingLocation
-								break retryloop
-							}
+						trace.begin()
+						siteerr = sess.do(addr, conntls, req, resp)
+						trace.end()
+						throttle.observe(siteerr)
 
-							var baseurl *url.URL
-							baseurl, siteerr = url.Parse(siteurl)
-							if siteerr != nil {
-								siteerr = fmt.Errorf("error parsing base URL %v: %v", siteurl, siteerr)
-								break retryloop
-							}
+						requestshandled++
 
-							var relativeurl *url.URL
-							relativeurl, siteerr = url.Parse(string(newlocation))
-							if siteerr != nil {
-								siteerr = fmt.Errorf("error parsing redirect location %v: %v", newlocation, siteerr)
-								break retryloop
-							}
+						ipaddress = sess.remoteaddr()
 
-							newurl := baseurl.ResolveReference(relativeurl)
+						if siteerr == nil {
+							code = resp.Header.StatusCode()
 
-							var newsiteurl string
-							newsiteurl, siteerr = url.JoinPath(newurl.Scheme+"://"+newurl.Host, newurl.Path)
-							if siteerr != nil {
-								siteerr = fmt.Errorf("error creating new site URL from %v: %v", newurl, siteerr)
+							if code == 200 || code == 206 {
+								body = string(resp.Body())
+								header = resp.Header.String()
+								errstring = ""
 								break retryloop
 							}
 
-							if strings.EqualFold(newsiteurl, siteurl) {
-								if !justnotcloserequest {
-									warnings = append(warnings, "redirect_to_self")
-									if closerequest {
-										closerequest = false
-										justnotcloserequest = true
-									} else {
-										siteerr = fasthttp.ErrTooManyRedirects
+							if code == fasthttp.StatusTooManyRequests || code == fasthttp.StatusServiceUnavailable {
+								if code == fasthttp.StatusTooManyRequests {
+									warnings = append(warnings, "rate_limited")
+								} else {
+									warnings = append(warnings, "service_unavailable")
+								}
+
+								if delay, ok := retryafter(resp.Header.Peek("Retry-After"), time.Now()); ok {
+									if delay > policy.max {
+										// Not waiting that long, give up
+										warnings = append(warnings, "retry_after_too_long")
 										break retryloop
 									}
-								} else {
-									justnotcloserequest = false
+									wait = delay
 								}
-							}
+							} else if fasthttp.StatusCodeIsRedirect(code) {
+								warnings = append(warnings, "redirect")
 
-							if host != newurl.Host {
-								warnings = append(warnings, "redirect_to_other_host")
-							}
+								redirectsleft--
+								if redirectsleft == 0 {
+									siteerr = fasthttp.ErrTooManyRedirects
+									break retryloop
+								}
+
+								newlocation := resp.Header.Peek("Location")
+								if len(newlocation) == 0 {
+									siteerr = fasthttp.ErrMissingLocation
+									break retryloop
+								}
+
+								var baseurl *url.URL
+								baseurl, siteerr = url.Parse(siteurl)
+								if siteerr != nil {
+									siteerr = fmt.Errorf("error parsing base URL %v: %v", siteurl, siteerr)
+									break retryloop
+								}
+
+								var relativeurl *url.URL
+								relativeurl, siteerr = url.Parse(string(newlocation))
+								if siteerr != nil {
+									siteerr = fmt.Errorf("error parsing redirect location %v: %v", newlocation, siteerr)
+									break retryloop
+								}
+
+								newurl := baseurl.ResolveReference(relativeurl)
+
+								var newsiteurl string
+								newsiteurl, siteerr = url.JoinPath(newurl.Scheme+"://"+newurl.Host, newurl.Path)
+								if siteerr != nil {
+									siteerr = fmt.Errorf("error creating new site URL from %v: %v", newurl, siteerr)
+									break retryloop
+								}
+
+								if strings.EqualFold(newsiteurl, siteurl) {
+									if !justnotcloserequest {
+										warnings = append(warnings, "redirect_to_self")
+										if closerequest {
+											closerequest = false
+											justnotcloserequest = true
+										} else {
+											siteerr = fasthttp.ErrTooManyRedirects
+											break retryloop
+										}
+									} else {
+										justnotcloserequest = false
+									}
+								}
+
+								if host != newurl.Host {
+									warnings = append(warnings, "redirect_to_other_host")
+								}
 
-							host = newurl.Host
+								host = newurl.Host
+
+								if urlpath != newurl.Path {
+									warnings = append(warnings, "redirect_to_other_path")
+								}
 
-							if urlpath != newurl.Path {
-								warnings = append(warnings, "redirect_to_other_path")
+								urlpath = newurl.Path
+
+								if protocol == "https" && newurl.Scheme == "http" {
+									warnings = append(warnings, "https_to_http_redirect")
+								}
+
+								protocol = newurl.Scheme
+								continue // retry
+							} else if code >= 500 && retriesleft > 1 {
+								// Server errors can pass, ask again after the backoff
+							} else if *allpaths || pathlists[pathno].host != "" {
+								// Crawled and sitemap pages have nothing to fall back to either
+								// Whatever this path gave us is the result for it
+								body = string(resp.Body())
+								header = resp.Header.String()
+								break retryloop
+							} else if urlpathindex+1 < len(fallbackpaths) {
+								// Try another default URL
+								urlpathindex++
+								urlpath = fallbackpaths[urlpathindex]
+
+								continue // retry
+							} else if code < 500 {
+								// Asking the same path again will get the same answer
+								break retryloop
 							}
+						} else {
+							// There was an error
+							code = 0
+							header = ""
+							body = ""
 
-							urlpath = newurl.Path
+							if siteerr == fasthttp.ErrBodyTooLarge {
+								siteerr = fmt.Errorf("%v (%v bytes)", siteerr.Error(), resp.Header.ContentLength())
+								break retryloop
+							} else if siteerr == errPolitenessWait {
+								time.Sleep(time.Second)
+								continue // try again, but it doesn't cost a retry
+							} else if _, ok := siteerr.(*net.DNSError); ok {
+								if !strings.HasPrefix(host, "www.") {
+									if host == sitehost {
+										sitehost = "www." + host
+									}
+									host = "www." + host
+									warnings = append(warnings, "prefix_www")
+									continue // loop without using a retry
+								}
+								// Just give up
+								break retryloop
+							} else if strings.Contains(siteerr.Error(), "tls: failed to verify certificate: x509: certificate is valid for") {
+								// Ignore bad certs
+								warnings = append(warnings, "tls_wrong_host")
+								tlsconfig = insecuretls
+								sitetls = insecuretls
+							} else if strings.Contains(siteerr.Error(), "tls: failed to verify certificate: x509: certificate signed by unknown authority") {
+								warnings = append(warnings, "tls_unknown_authority")
+								tlsconfig = insecuretls
+								sitetls = insecuretls
+							} else if strings.Contains(siteerr.Error(), "tls: failed to verify certificate: x509: certificate has expired or is not yet valid") {
+								warnings = append(warnings, "tls_expired_cert")
+								tlsconfig = insecuretls
+								sitetls = insecuretls
+							} else if siteerr.Error() == "remote error: tls: internal error" {
+								warnings = append(warnings, "unencrypted_http_failback")
+								protocol = "http"
+								siteprotocol = "http"
+							} else if errors.Is(siteerr, syscall.ECONNREFUSED) || strings.Contains(siteerr.Error(), "connectex: No connection could be made because the target machine actively refused it") {
+								// Give up
+								warnings = append(warnings, "connection_refused")
+								break retryloop
+							} else if siteerr == fasthttp.ErrTooManyRedirects {
+								// Give up
+								break retryloop
+							} else if siteerr.Error() == "the server closed connection before returning the first response byte. Make sure the server returns 'Connection: close' response header before closing the connection" {
 
-							if protocol == "https" && newurl.Scheme == "http" {
-								warnings = append(warnings, "https_to_http_redirect")
+							} else {
+								// other errors
+								warnings = append(warnings, strings.ReplaceAll(siteerr.Error(), " ", "_"))
 							}
+						}
 
-							protocol = newurl.Scheme
-							continue // retry
-						} else if urlpathindex+1 < len(*urlpaths) {
-							// Try another default URL
-							urlpathindex++
-							urlpath = (*urlpaths)[urlpathindex]
+						if *showerrors {
+							if siteerr != nil {
+								log.Println("Connecting to", siteurl, "error:", siteerr.Error())
+							} else {
+								log.Println("Connecting to", siteurl, "result code:", code)
+							}
+						}
 
-							continue // retry
+						// No point in waiting after the last attempt
+						if retriesleft > 1 {
+							time.Sleep(wait)
 						}
-					} else {
-						// There was an error
-						code = 0
-						header = ""
-						body = ""
-
-						if siteerr == fasthttp.ErrBodyTooLarge {
-							siteerr = fmt.Errorf("%v (%v bytes)", siteerr.Error(), resp.Header.ContentLength())
-							break retryloop
-						} else if siteerr == fasthttp.ErrNoFreeConns {
-							time.Sleep(time.Second)
-							continue // try again, but it doesn't cost a retry
-						} else if _, ok := siteerr.(*net.DNSError); ok {
-							if !strings.HasPrefix(host, "www.") {
-								host = "www." + host
-								warnings = append(warnings, "prefix_www")
-								continue // loop without using a retry
+						retriesleft--
+					}
+					tlsinfo := sess.tlsdetails()
+					timings := trace.result()
+
+					if *jarmenable && jarmhash == "" && ipaddress != "" && protocol == "https" {
+						hostname, addr := host, host+":443"
+						if h, _, err := net.SplitHostPort(host); err == nil {
+							hostname, addr = h, host
+						}
+						// The probes need connection slots of their own with --maxperip and --maxperdomain
+						sess.close()
+						jarmhash = jarm(sharedDialer, hostname, addr, timeoutDuration)
+					}
+
+					if siteerr != nil {
+						errstring = siteerr.Error()
+					}
+
+					var hit bool
+					if valid, found := validators[fallbackpaths[0]]; found && siteerr == nil {
+						hit = valid(bundleresponse{
+							code:       code,
+							header:     header,
+							body:       []byte(body),
+							redirected: slices.Contains(warnings, "redirect"),
+						})
+						if !hit && code == 200 {
+							warnings = append(warnings, "soft_404")
+						}
+					}
+
+					// The sitemaps are found relative to where the first path ended up
+					if *sitemap && !sitemapdone && siteerr == nil && pathlists[pathno].host == "" {
+						sitemapdone = true
+						if base, err := url.Parse(siteurl); err == nil {
+							var refs []string
+							if robots != nil {
+								refs = robots.host(base.Scheme+"://"+base.Host, func() *robotsrules {
+									return fetchrobots(sess, base.Scheme, base.Host, extratls, *useragent, *maxredirects)
+								}).sitemaps()
+							} else if rules := fetchrobots(sess, base.Scheme, base.Host, extratls, *useragent, *maxredirects); rules != nil {
+								refs = rules.sitemaps
 							}
-							// Just give up
-							break retryloop
-						} else if strings.Contains(siteerr.Error(), "tls: failed to verify certificate: x509: certificate is valid for") {
-							// Ignore bad certs
-							warnings = append(warnings, "tls_wrong_host")
-							hostclient.TLSConfig = insecuretls
-						} else if strings.Contains(siteerr.Error(), "tls: failed to verify certificate: x509: certificate signed by unknown authority") {
-							warnings = append(warnings, "tls_unknown_authority")
-							hostclient.TLSConfig = insecuretls
-						} else if strings.Contains(siteerr.Error(), "tls: failed to verify certificate: x509: certificate has expired or is not yet valid") {
-							warnings = append(warnings, "tls_expired_cert")
-							hostclient.TLSConfig = insecuretls
-						} else if siteerr.Error() == "remote error: tls: internal error" {
-							warnings = append(warnings, "unencrypted_http_failback")
-							protocol = "http"
-						} else if strings.Contains(siteerr.Error(), "connectex: No connection could be made because the target machine actively refused it") {
-							// Give up
-							warnings = append(warnings, "connection_refused")
-							break retryloop
-						} else if siteerr == fasthttp.ErrTooManyRedirects {
-							// Give up
-							break retryloop
-						} else if siteerr.Error() == "the server closed connection before returning the first response byte. Make sure the server returns 'Connection: close' response header before closing the connection" {
+							for _, page := range sitemapurls(sess, base, refs, extratls, *useragent, *maxredirects, *maxresponsesize, *maxsitemapurls) {
+								if crawlkey(page) == crawlkey(base) {
+									continue
+								}
+								sitecrawl.visit(page.String())
+								pathlists = append(pathlists, pathlist{
+									paths:    []string{page.RequestURI()},
+									host:     page.Host,
+									protocol: page.Scheme,
+								})
+							}
+						}
+					}
 
-						} else {
-							// other errors
-							warnings = append(warnings, strings.ReplaceAll(siteerr.Error(), " ", "_"))
+					if probing && pathno == 0 {
+						if siteerr == nil {
+							sitenotfound = newNotfound(code, fallbackpaths[0], []byte(body))
+							continue
+						}
+						// Store the error, and don't bother with the wordlist
+						probefailed = true
+					} else if isword {
+						if siteerr != nil || sitenotfound.matches(code, fallbackpaths[0], []byte(body), *simhashdistance) {
+							continue
 						}
+						hit = true
 					}
 
-					time.Sleep(time.Second)
+					if pathlists[pathno].depth == 0 {
+						sitecrawl.visit(siteurl)
+					}
+					for _, link := range sitecrawl.links(siteurl, pathlists[pathno].depth, header, body) {
+						pathlists = append(pathlists, pathlist{
+							paths:    []string{link.RequestURI()},
+							host:     link.Host,
+							protocol: link.Scheme,
+							depth:    pathlists[pathno].depth + 1,
+						})
+					}
 
-					if *showerrors {
-						log.Println("Connecting to", siteurl, "error:", siteerr.Error())
+					// Check if we should save this or not
+					if codes != nil {
+						if _, found := codes[code]; !found {
+							continue
+						}
 					}
-					retriesleft--
-				}
 
-				// Check if we should save this or not
-				if codes != nil {
-					if _, found := codes[code]; !found {
-						continue
+					// Unique warnings only
+					slices.Sort(warnings)
+					warnings = slices.Compact(warnings)
+
+					var htmlmetadata *turbograb.HTMLMetadata
+					if (*metadata || techrules != nil) && htmlpage(header, body) {
+						htmlmetadata = turbograb.ParseHTML(siteurl, body)
 					}
-				}
 
-				if siteerr != nil {
-					errstring = siteerr.Error()
-				}
+					// The favicon goes with the first page that worked
+					var faviconhash string
+					if techrules != nil && !favicondone && siteerr == nil {
+						favicondone = true
+						var iconurl string
+						if htmlmetadata != nil {
+							iconurl = htmlmetadata.Icon
+						}
+						faviconhash = fetchfavicon(sess, siteurl, iconurl, extratls, *useragent, *maxredirects)
+					}
 
-				// Unique warnings only
-				slices.Sort(warnings)
-				warnings = slices.Compact(warnings)
-
-				// Ship it!
-				result := turbograb.Result{
-					Site:         site,
-					URL:          siteurl,
-					Certificates: certinfo,
-					Header:       header,
-					Body:         body,
-					IPaddress:    ipaddress,
-					Code:         code,
-					Error:        errstring,
-					Warnings:     warnings,
-				}
+					result := turbograb.Result{
+						Site:         site,
+						URL:          siteurl,
+						Certificates: certinfo,
+						Header:       header,
+						Body:         body,
+						IPaddress:    ipaddress,
+						Code:         code,
+						Error:        errstring,
+						Warnings:     warnings,
+						Timings:      timings,
+						TLS:          tlsinfo,
+						Validation:   certvalidator.validate(certinfo, host, time.Now()),
+						JARM:         jarmhash,
+						Hit:          hit,
+						Depth:        pathlists[pathno].depth,
+						Robots:       robotsdecision,
+						HTML:         htmlmetadata,
+						FaviconHash:  faviconhash,
+					}
+					if techrules != nil && siteerr == nil {
+						result.Technologies = techrules.Detect(&result)
+					}
+					if !*metadata {
+						result.HTML = nil
+					}
 
-				var jd []byte
-				switch *format {
-				case "json":
-					jd = generateJSON(result)
-				case "txt":
-					jd = generateTXT(result)
-				default:
-					log.Println("Unknown format", *format)
-					os.Exit(1)
-				}
-				encodedQueue <- turbograb.Encoded{
-					Site: result.Site,
-					Data: jd,
+					// Ship it!
+					emit(result)
 				}
+				sess.close()
+
+				flush(site)
+				batchpart = 0
 			}
 			producerWG.Done()
-		}()
+		}(i)
 	}
 
 	maxwriters := 1
@@ -442,7 +1065,7 @@ func main() {
 			var writer io.Writer
 			for encoded := range encodedQueue {
 				if file == nil {
-					filename = generateFilename(*outputfolder, encoded.Site, *recordsperfile, *buckets, *format, *compression)
+					filename = generateFilename(*outputfolder, encoded.Site, encoded.Part, *recordsperfile, *buckets, *format, *compression)
 					f, err := os.Create(filename)
 					if err != nil {
 						log.Printf("Error creating output file %v: %v", filename, err)
@@ -468,8 +1091,9 @@ func main() {
 				if err != nil {
 					log.Printf("Error writing output file %v: %v", filename, err)
 				}
-				written++
-				if written == *recordsperfile {
+				// Batches are never split, so a file can end up with a few more records than asked for
+				written += encoded.Records
+				if written >= *recordsperfile {
 					if *compression {
 						lz.Close()
 					}
@@ -485,20 +1109,28 @@ func main() {
 
 	for _, site := range sites {
 		site = strings.Trim(site, "\r")
+		discoverer.queued()
 		producerQueue <- site
 		pb.Add(1)
 	}
+	discoverer.feed(producerQueue, pb)
 
 	close(producerQueue)
+	throttle.stop()
 	producerWG.Wait()
 	pb.Finish()
 
 	close(encodedQueue)
 	writerWG.Wait()
+
+	stats.report(os.Stderr)
 }
 
-func generateFilename(folder, name string, itemsperfile, buckets int, format string, compression bool) string {
+func generateFilename(folder, name string, part, itemsperfile, buckets int, format string, compression bool) string {
 	filename := name
+	if part > 0 {
+		filename += "-" + strconv.Itoa(part)
+	}
 
 	if buckets > 1 {
 		hashbucket := uint64(xxhash.Checksum64S([]byte(name), 0)) % uint64(buckets)
@@ -528,7 +1160,7 @@ func generateJSON(data turbograb.Result) []byte {
 
 func generateTXT(data turbograb.Result) []byte {
 	var buffer bytes.Buffer
-	buffer.Grow(len(data.Header) + len(data.Body) + 128)
+	buffer.Grow(len(data.Header) + len(data.Banner) + len(data.Raw) + len(data.Body) + 128)
 
 	buffer.WriteString("*Site: ")
 	buffer.WriteString(data.Site)
@@ -538,6 +1170,12 @@ func generateTXT(data turbograb.Result) []byte {
 	buffer.WriteString(data.URL)
 	buffer.WriteString("\n")
 
+	if data.Protocol != "" {
+		buffer.WriteString("*Protocol: ")
+		buffer.WriteString(data.Protocol)
+		buffer.WriteString("\n")
+	}
+
 	if len(data.Warnings) > 0 {
 		buffer.WriteString("*Warnings: ")
 		buffer.WriteString(strings.Join(data.Warnings, ", "))
@@ -552,7 +1190,94 @@ func generateTXT(data turbograb.Result) []byte {
 		buffer.WriteString("*IP: ")
 		buffer.WriteString(data.IPaddress)
 		buffer.WriteString("\n")
-		buffer.WriteString(fmt.Sprintf("*Resultcode: %v\n", data.Code))
+		if data.Code != 0 {
+			buffer.WriteString(fmt.Sprintf("*Resultcode: %v\n", data.Code))
+		}
+	}
+
+	if data.Timings != nil {
+		t := data.Timings
+		buffer.WriteString(fmt.Sprintf("*Timings: dns=%v connect=%v tls=%v firstbyte=%v transfer=%v total=%v attempts=%v\n",
+			t.DNS, t.Connect, t.TLSHandshake, t.FirstByte, t.Transfer, t.Total, t.Attempts))
+	}
+
+	if data.TLS != nil {
+		t := data.TLS
+		buffer.WriteString(fmt.Sprintf("*TLS: version=%v cipher=%v alpn=%v servername=%v resumed=%v ocsp=%v scts=%v\n",
+			t.Version, t.CipherSuite, t.ALPN, t.ServerName, t.Resumed, len(t.OCSPResponse), len(t.SCTs)))
+	}
+
+	for _, support := range data.TLSSupport {
+		if support.Accepted {
+			buffer.WriteString(fmt.Sprintf("*TLS support: %v accepted %v\n", support.Version, strings.Join(support.CipherSuites, " ")))
+		} else {
+			buffer.WriteString(fmt.Sprintf("*TLS support: %v rejected\n", support.Version))
+		}
+	}
+
+	if data.Hit {
+		buffer.WriteString("*Hit: true\n")
+	}
+
+	if data.Robots != "" {
+		buffer.WriteString("*Robots: ")
+		buffer.WriteString(data.Robots)
+		buffer.WriteString("\n")
+	}
+
+	if data.Depth > 0 {
+		buffer.WriteString(fmt.Sprintf("*Depth: %v\n", data.Depth))
+	}
+
+	if data.JARM != "" {
+		buffer.WriteString("*JARM: ")
+		buffer.WriteString(data.JARM)
+		buffer.WriteString("\n")
+	}
+
+	if data.HTML != nil {
+		writeHTMLMetadata(&buffer, data.HTML)
+	}
+
+	if data.FaviconHash != "" {
+		buffer.WriteString("*Favicon: ")
+		buffer.WriteString(data.FaviconHash)
+		buffer.WriteString("\n")
+	}
+
+	for _, t := range data.Technologies {
+		if t.Version != "" {
+			buffer.WriteString(fmt.Sprintf("*Technology: %v %v (%v%%)\n", t.Name, t.Version, t.Confidence))
+		} else {
+			buffer.WriteString(fmt.Sprintf("*Technology: %v (%v%%)\n", t.Name, t.Confidence))
+		}
+	}
+
+	if data.Validation != nil {
+		v := data.Validation
+		buffer.WriteString(fmt.Sprintf("*Validation: chainvalid=%v hostnamevalid=%v expired=%v notyetvalid=%v notafter=%v\n",
+			v.ChainValid, v.HostnameValid, v.Expired, v.NotYetValid, v.NotAfter.Format(time.RFC3339)))
+		if len(v.WeakKeys) > 0 {
+			buffer.WriteString("*Weak keys: ")
+			buffer.WriteString(strings.Join(v.WeakKeys, ", "))
+			buffer.WriteString("\n")
+		}
+		if len(v.WeakSignatures) > 0 {
+			buffer.WriteString("*Weak signatures: ")
+			buffer.WriteString(strings.Join(v.WeakSignatures, ", "))
+			buffer.WriteString("\n")
+		}
+		for _, verr := range v.Errors {
+			buffer.WriteString("*Validation error: ")
+			buffer.WriteString(verr)
+			buffer.WriteString("\n")
+		}
+	}
+
+	for _, fingerprint := range data.CertificateRefs {
+		buffer.WriteString("*Certificate: ")
+		buffer.WriteString(fingerprint)
+		buffer.WriteString("\n")
 	}
 
 	if len(data.Certificates) > 0 {
@@ -569,6 +1294,8 @@ func generateTXT(data turbograb.Result) []byte {
 	if data.Error == "" {
 		buffer.WriteString("-----\n")
 		buffer.WriteString(data.Header)
+		buffer.WriteString(data.Banner)
+		buffer.Write(data.Raw)
 		buffer.WriteString("=====\n")
 		buffer.WriteString(data.Body)
 		buffer.WriteString("\n")
@@ -591,3 +1318,39 @@ func storecertinfo(store *[]*x509.Certificate) func(rawCerts [][]byte, verifiedC
 		return nil
 	}
 }
+
+func writeHTMLMetadata(buffer *bytes.Buffer, md *turbograb.HTMLMetadata) {
+	line := func(key, value string) {
+		if value != "" {
+			buffer.WriteString("*" + key + ": ")
+			buffer.WriteString(value)
+			buffer.WriteString("\n")
+		}
+	}
+	line("Title", md.Title)
+	line("Description", md.Description)
+	line("Generator", md.Generator)
+	line("Canonical", md.Canonical)
+	line("Icon", md.Icon)
+	line("Language", md.Language)
+	if md.Forms > 0 {
+		line("Forms", strconv.Itoa(md.Forms))
+	}
+	properties := make([]string, 0, len(md.OpenGraph))
+	for property := range md.OpenGraph {
+		properties = append(properties, property)
+	}
+	slices.Sort(properties)
+	for _, property := range properties {
+		line("OpenGraph", property+"="+md.OpenGraph[property])
+	}
+	for _, script := range md.Scripts {
+		line("Script", script)
+	}
+	for _, stylesheet := range md.Stylesheets {
+		line("Stylesheet", stylesheet)
+	}
+	for _, host := range md.LinkHosts {
+		line("Link host", host)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"strings"
+	"unicode"
+
+	"github.com/OneOfOne/xxhash"
+)
+
+// notfound is the signature of what a site answers for a path that doesn't exist, so
+// wordlist hits that are really the same soft 404 page can be left out
+type notfound struct {
+	code    int
+	length  int
+	simhash uint64
+}
+
+// newNotfound makes the signature from the answer for path
+func newNotfound(code int, path string, body []byte) *notfound {
+	body = withoutpath(body, path)
+	return &notfound{
+		code:    code,
+		length:  len(body),
+		simhash: simhash(body),
+	}
+}
+
+// matches tells if the answer for path looks like the not found answer, distance is how many bits of
+// simhash may differ for pages to count as the same
+func (n *notfound) matches(code int, path string, body []byte, distance int) bool {
+	if code == 404 || code == 410 {
+		// Sites with soft 404 pages can still say so for some paths
+		return true
+	}
+	if n == nil || code != n.code {
+		return false
+	}
+	// Same status for errors and redirects is enough, the body is just noise
+	if code < 200 || code > 299 {
+		return true
+	}
+	body = withoutpath(body, path)
+	return len(body) == n.length || bits.OnesCount64(simhash(body)^n.simhash) <= distance
+}
+
+// withoutpath removes the path from body, lots of not found pages repeat what was asked for
+func withoutpath(body []byte, path string) []byte {
+	body = bytes.ReplaceAll(body, []byte(path), nil)
+	return bytes.ReplaceAll(body, []byte(strings.TrimPrefix(path, "/")), nil)
+}
+
+// randompath returns a path that surely doesn't exist on any site
+func randompath() string {
+	return fmt.Sprintf("/%016x%08x", rand.Uint64(), rand.Uint32())
+}
+
+// simhash is a 64 bit locality sensitive hash of the words in body, similar pages get hashes
+// that only differ in a few bits
+func simhash(body []byte) uint64 {
+	var weights [64]int
+	for _, word := range bytes.FieldsFunc(body, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		hash := xxhash.Checksum64(bytes.ToLower(word))
+		for i := range weights {
+			if hash&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	var result uint64
+	for i, weight := range weights {
+		if weight > 0 {
+			result |= 1 << i
+		}
+	}
+	return result
+}
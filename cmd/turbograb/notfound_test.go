@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNotfoundMatches(t *testing.T) {
+	soft := []byte("<html><head><title>Oops</title></head><body>We looked everywhere but " + strings.Repeat("could not find the page you asked for ", 5) + "/abc123</body></html>")
+	softother := []byte("<html><head><title>Oops</title></head><body>We looked everywhere but " + strings.Repeat("could not find the page you asked for ", 5) + "/admin/login</body></html>")
+	page := []byte("<html><head><title>Admin</title></head><body><form><input name=user><input type=password name=pass></form>" + strings.Repeat("Log in to manage the site ", 5) + "</body></html>")
+
+	for _, test := range []struct {
+		name     string
+		probe    *notfound
+		code     int
+		body     []byte
+		notfound bool
+	}{
+		{"soft 404 page", newNotfound(200, "/abc123", soft), 200, softother, true},
+		{"real page on soft 404 site", newNotfound(200, "/abc123", soft), 200, page, false},
+		{"404 on soft 404 site", newNotfound(200, "/abc123", soft), 404, nil, true},
+		{"410 on soft 404 site", newNotfound(200, "/abc123", soft), 410, nil, true},
+		{"404 on 404 site", newNotfound(404, "/abc123", nil), 404, nil, true},
+		{"page on 404 site", newNotfound(404, "/abc123", nil), 200, page, false},
+		{"same redirect", newNotfound(302, "/abc123", nil), 302, nil, true},
+		{"403 on 404 site", newNotfound(404, "/abc123", nil), 403, nil, false},
+		{"404 without a probe", nil, 404, nil, true},
+		{"page without a probe", nil, 200, page, false},
+	} {
+		if notfound := test.probe.matches(test.code, "/admin/login", test.body, 3); notfound != test.notfound {
+			t.Errorf("%v: matches = %v, want %v", test.name, notfound, test.notfound)
+		}
+	}
+}
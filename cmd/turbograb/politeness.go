@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+var errPolitenessWait = errors.New("timed out waiting for politeness limit")
+
+// politeness limits how hard all producers combined hit a single IP address or
+// registrable domain. Concurrency limits apply to connections and rate limits to requests,
+// a nil *politeness allows everything.
+type politeness struct {
+	perip, perdomain *keylimiter
+}
+
+func newPoliteness(maxperip, maxperdomain int, rateperip, rateperdomain float64) *politeness {
+	if maxperip <= 0 && maxperdomain <= 0 && rateperip <= 0 && rateperdomain <= 0 {
+		return nil
+	}
+	return &politeness{
+		perip:     newKeylimiter(maxperip, rateperip),
+		perdomain: newKeylimiter(maxperdomain, rateperdomain),
+	}
+}
+
+// domain waits until a new connection to host is allowed by the registrable domain limits
+func (p *politeness) domain(host string, deadline time.Time) (func(), error) {
+	if p == nil {
+		return func() {}, nil
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		domain = host
+	}
+	return p.perdomain.acquire(domain, deadline)
+}
+
+// ip waits until a new connection to ip is allowed by the IP address limits
+func (p *politeness) ip(ip net.IP, deadline time.Time) (func(), error) {
+	if p == nil {
+		return func() {}, nil
+	}
+	return p.perip.acquire(ip.String(), deadline)
+}
+
+// request waits until another request over an open connection to host at ip is allowed by the rate
+// limits, dialing counts as the first request on a connection
+func (p *politeness) request(host string, ip net.IP, deadline time.Time) error {
+	if p == nil {
+		return nil
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		domain = host
+	}
+	if err = p.perdomain.pace(domain, deadline); err != nil {
+		return err
+	}
+	return p.perip.pace(ip.String(), deadline)
+}
+
+// keylimiter enforces a max number of concurrent holders and a max rate of acquisitions per key
+type keylimiter struct {
+	maxconns int
+	interval time.Duration
+
+	lock     sync.Mutex
+	keys     map[string]*keystate
+	acquires int
+}
+
+type keystate struct {
+	slots chan struct{} // nil if there is no concurrency limit
+	refs  int           // holders and waiters, state can be dropped when zero
+	next  time.Time     // earliest time for the next acquisition
+}
+
+func newKeylimiter(maxconns int, rate float64) *keylimiter {
+	if maxconns <= 0 && rate <= 0 {
+		return nil
+	}
+	kl := &keylimiter{
+		maxconns: maxconns,
+		keys:     make(map[string]*keystate),
+	}
+	if rate > 0 {
+		kl.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return kl
+}
+
+// acquire blocks until key is below its limits or the deadline passes, and returns a function
+// that must be called when the connection is done
+func (kl *keylimiter) acquire(key string, deadline time.Time) (func(), error) {
+	if kl == nil {
+		return func() {}, nil
+	}
+
+	ks := kl.ref(key)
+	if ks.slots != nil {
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case ks.slots <- struct{}{}:
+			timer.Stop()
+		case <-timer.C:
+			kl.unref(key, ks)
+			return nil, errPolitenessWait
+		}
+	}
+
+	if err := kl.wait(ks, deadline); err != nil {
+		kl.release(key, ks)
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			kl.release(key, ks)
+		})
+	}, nil
+}
+
+// pace blocks until key is below its rate limit or the deadline passes, without taking a slot
+func (kl *keylimiter) pace(key string, deadline time.Time) error {
+	if kl == nil || kl.interval == 0 {
+		return nil
+	}
+	ks := kl.ref(key)
+	err := kl.wait(ks, deadline)
+	kl.unref(key, ks)
+	return err
+}
+
+// ref returns the state for key, which must be unreferenced again when done with
+func (kl *keylimiter) ref(key string) *keystate {
+	kl.lock.Lock()
+	defer kl.lock.Unlock()
+	kl.acquires++
+	if kl.acquires%10000 == 0 {
+		kl.sweep()
+	}
+	ks := kl.keys[key]
+	if ks == nil {
+		ks = &keystate{}
+		if kl.maxconns > 0 {
+			ks.slots = make(chan struct{}, kl.maxconns)
+		}
+		kl.keys[key] = ks
+	}
+	ks.refs++
+	return ks
+}
+
+// wait sleeps until the rate limit allows the next acquisition for ks
+func (kl *keylimiter) wait(ks *keystate, deadline time.Time) error {
+	if kl.interval == 0 {
+		return nil
+	}
+	kl.lock.Lock()
+	at := time.Now()
+	if ks.next.After(at) {
+		at = ks.next
+	}
+	if at.After(deadline) {
+		kl.lock.Unlock()
+		return errPolitenessWait
+	}
+	ks.next = at.Add(kl.interval)
+	kl.lock.Unlock()
+	time.Sleep(time.Until(at))
+	return nil
+}
+
+func (kl *keylimiter) release(key string, ks *keystate) {
+	if ks.slots != nil {
+		<-ks.slots
+	}
+	kl.unref(key, ks)
+}
+
+func (kl *keylimiter) unref(key string, ks *keystate) {
+	kl.lock.Lock()
+	ks.refs--
+	if ks.refs == 0 && !ks.next.After(time.Now()) {
+		delete(kl.keys, key)
+	}
+	kl.lock.Unlock()
+}
+
+// sweep drops state for keys that no longer carry any restrictions, call with lock held
+func (kl *keylimiter) sweep() {
+	now := time.Now()
+	for key, ks := range kl.keys {
+		if ks.refs == 0 && !ks.next.After(now) {
+			delete(kl.keys, key)
+		}
+	}
+}
+
+// releaseConn calls release once when the connection is closed
+type releaseConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *releaseConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
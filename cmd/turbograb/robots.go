@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errRobotsDisallowed = errors.New("disallowed by robots.txt")
+
+// robotsmaxage is how long robots.txt is kept after fetching it, sites are grabbed one after the other so
+// a host is rarely needed for long
+const robotsmaxage = 10 * time.Minute
+
+// robotscache holds what robots.txt says for each host, shared by all producers so it's only fetched once
+type robotscache struct {
+	lock    sync.Mutex
+	hosts   map[string]*robotshost
+	lookups int
+}
+
+// robotshost is the robots.txt for one scheme, host and port, and when the next request may go out
+type robotshost struct {
+	ready   chan struct{} // closed when rules have been fetched
+	rules   *robotsrules
+	fetched time.Time
+
+	lock sync.Mutex
+	next time.Time
+}
+
+// robotsrules are the rules from the group in robots.txt that applies to us
+type robotsrules struct {
+	disallowall bool
+	rules       []robotsrule
+	delay       time.Duration
+	sitemaps    []string // these apply to everyone
+}
+
+type robotsrule struct {
+	allow   bool
+	pattern string
+}
+
+func newRobotscache() *robotscache {
+	return &robotscache{
+		hosts: make(map[string]*robotshost),
+	}
+}
+
+// host returns the robots.txt for a scheme and host, the first caller fetches it and the rest wait for that
+func (rc *robotscache) host(key string, fetch func() *robotsrules) *robotshost {
+	rc.lock.Lock()
+	rc.lookups++
+	if rc.lookups%1000 == 0 {
+		rc.sweep()
+	}
+	rh, found := rc.hosts[key]
+	if !found {
+		rh = &robotshost{
+			ready: make(chan struct{}),
+		}
+		rc.hosts[key] = rh
+	}
+	rc.lock.Unlock()
+
+	if found {
+		<-rh.ready
+		return rh
+	}
+	rh.rules = fetch()
+	rh.fetched = time.Now()
+	if rh.rules != nil {
+		rh.next = rh.fetched.Add(rh.rules.delay)
+	}
+	close(rh.ready)
+	return rh
+}
+
+// sweep drops hosts fetched more than robotsmaxage ago that aren't holding back a request, call with lock held
+func (rc *robotscache) sweep() {
+	now := time.Now()
+	for key, rh := range rc.hosts {
+		select {
+		case <-rh.ready:
+		default:
+			continue // still fetching
+		}
+		rh.lock.Lock()
+		expired := now.Sub(rh.fetched) > robotsmaxage && !rh.next.After(now)
+		rh.lock.Unlock()
+		if expired {
+			delete(rc.hosts, key)
+		}
+	}
+}
+
+// allowed tells if path may be grabbed
+func (rh *robotshost) allowed(path string) bool {
+	r := rh.rules
+	if r == nil || path == "/robots.txt" {
+		return true
+	}
+	if r.disallowall {
+		return false
+	}
+	allow, longest := true, -1
+	for _, rule := range r.rules {
+		if len(rule.pattern) < longest || !robotsmatch(rule.pattern, path) {
+			continue
+		}
+		// The longest match wins, allow wins a tie
+		if len(rule.pattern) > longest || rule.allow {
+			allow = rule.allow
+		}
+		longest = len(rule.pattern)
+	}
+	return allow
+}
+
+// delay returns the Crawl-delay for the host
+func (rh *robotshost) delay() time.Duration {
+	if rh.rules == nil {
+		return 0
+	}
+	return rh.rules.delay
+}
+
+// sitemaps returns the sitemaps robots.txt refers to
+func (rh *robotshost) sitemaps() []string {
+	if rh.rules == nil {
+		return nil
+	}
+	return rh.rules.sitemaps
+}
+
+// wait sleeps until a request to the host is allowed by Crawl-delay, and returns how long that was
+func (rh *robotshost) wait() time.Duration {
+	delay := rh.delay()
+	if delay == 0 {
+		return 0
+	}
+	rh.lock.Lock()
+	now := time.Now()
+	start := rh.next
+	if start.Before(now) {
+		start = now
+	}
+	rh.next = start.Add(delay)
+	rh.lock.Unlock()
+
+	wait := start.Sub(now)
+	time.Sleep(wait)
+	return wait
+}
+
+// fetchrobots gets robots.txt for host over the producer's session. Missing files allow everything, and servers
+// that fail disallow everything, like RFC 9309 says. If the host can't be reached at all the grab will fail anyway,
+// so nil is returned and everything is allowed.
+func fetchrobots(sess *session, protocol, host string, tlsconfig *tls.Config, agent string, maxredirects int) *robotsrules {
+	code, body, err := sess.fetch(protocol+"://"+host+"/robots.txt", tlsconfig, agent, maxredirects, nil)
+	switch {
+	case err != nil:
+		return nil
+	case code >= 200 && code <= 299:
+		return parserobots(body, agent)
+	case code == fasthttp.StatusTooManyRequests || code >= 500:
+		return &robotsrules{disallowall: true}
+	}
+	return &robotsrules{}
+}
+
+// parserobots returns the rules for agent, from the group with the longest user-agent that's part of
+// agent, or the * group if none are
+func parserobots(body []byte, agent string) *robotsrules {
+	agent = strings.ToLower(agent)
+
+	type group struct {
+		agents []string
+		rules  []robotsrule
+		delay  time.Duration
+	}
+	var groups []*group
+	var current *group
+	var inrules bool
+	var sitemaps []string
+
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if comment := bytes.IndexByte(line, '#'); comment >= 0 {
+			line = line[:comment]
+		}
+		key, value, found := strings.Cut(string(line), ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if current == nil || inrules {
+				current = &group{}
+				groups = append(groups, current)
+				inrules = false
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if current == nil {
+				continue
+			}
+			inrules = true
+			if value != "" {
+				current.rules = append(current.rules, robotsrule{
+					allow:   key == "allow",
+					pattern: value,
+				})
+			}
+		case "sitemap":
+			if value != "" {
+				sitemaps = append(sitemaps, value)
+			}
+		case "crawl-delay":
+			if current == nil {
+				continue
+			}
+			inrules = true
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.delay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+
+	// Pick the most specific user-agent that applies to us, groups for the same one are merged
+	best := ""
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if a != "*" && strings.Contains(agent, a) && len(a) > len(best) {
+				best = a
+			}
+		}
+	}
+	if best == "" {
+		best = "*"
+	}
+
+	result := &robotsrules{
+		sitemaps: sitemaps,
+	}
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if a == best {
+				result.rules = append(result.rules, g.rules...)
+				result.delay = max(result.delay, g.delay)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// robotsmatch tells if path matches a robots.txt pattern, where * matches anything and $ anchors at the end
+func robotsmatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(path[pos:], part)
+		}
+		index := strings.Index(path[pos:], part)
+		if index < 0 {
+			return false
+		}
+		pos += index + len(part)
+	}
+	return !anchored || pos == len(path)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRobotsmatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern, path string
+		match         bool
+	}{
+		{"/", "/", true},
+		{"/", "/anything", true},
+		{"/fish", "/fish", true},
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish.asp", false},
+		{"/fish", "/catfish", false},
+		{"/fish/", "/fish", false},
+		{"/*.php", "/index.php", true},
+		{"/*.php", "/filename.php?parameters", true},
+		{"/*.php", "/windows.PHP", false},
+		{"/fish*.php", "/fish.php", true},
+		{"/fish*.php", "/fishheads/catfish.php?parameters", true},
+		{"/fish*.php", "/Fish.PHP", false},
+		{"/*.php$", "/filename.php", true},
+		{"/*.php$", "/filename.php?parameters", false},
+		{"/*.php$", "/filename.php5", false},
+		{"/fish$", "/fish", true},
+		{"/fish$", "/fishy", false},
+		{"/$", "/", true},
+		{"/$", "/index.html", false},
+		{"/a*b$", "/axbxb", true},
+		{"/a*b$", "/axbx", false},
+		{"/a**b", "/ab", true},
+		{"*", "/anything", true},
+	} {
+		if match := robotsmatch(test.pattern, test.path); match != test.match {
+			t.Errorf("robotsmatch(%q, %q) = %v, want %v", test.pattern, test.path, match, test.match)
+		}
+	}
+}
+
+func TestParserobots(t *testing.T) {
+	const agent = "Mozilla/5.0 (compatible; TurboGrab/1.0)"
+	for _, test := range []struct {
+		name       string
+		body       string
+		allowed    []string
+		disallowed []string
+		delay      time.Duration
+	}{
+		{
+			name:    "empty",
+			body:    "",
+			allowed: []string{"/", "/private"},
+		},
+		{
+			name:       "star group",
+			body:       "User-agent: *\nDisallow: /private/\n",
+			allowed:    []string{"/", "/private"},
+			disallowed: []string{"/private/", "/private/x"},
+		},
+		{
+			name:       "own group over star",
+			body:       "User-agent: *\nDisallow: /\n\nUser-agent: turbograb\nDisallow: /own/\nCrawl-delay: 2\n",
+			allowed:    []string{"/", "/private/"},
+			disallowed: []string{"/own/x"},
+			delay:      2 * time.Second,
+		},
+		{
+			name:       "other agents ignored",
+			body:       "User-agent: googlebot\nDisallow: /google/\n\nUser-agent: *\nDisallow: /star/\n",
+			allowed:    []string{"/google/"},
+			disallowed: []string{"/star/"},
+		},
+		{
+			name:       "longest agent wins",
+			body:       "User-agent: turbo\nDisallow: /short/\n\nUser-agent: turbograb/1\nDisallow: /long/\n",
+			allowed:    []string{"/short/"},
+			disallowed: []string{"/long/"},
+		},
+		{
+			name:       "agents share a group",
+			body:       "User-agent: googlebot\nUser-agent: TurboGrab\nDisallow: /shared/\n",
+			disallowed: []string{"/shared/"},
+		},
+		{
+			name:       "groups for the same agent are merged",
+			body:       "User-agent: turbograb\nDisallow: /a/\n\nUser-agent: *\nDisallow: /\n\nUser-agent: turbograb\nDisallow: /b/\n",
+			allowed:    []string{"/c/"},
+			disallowed: []string{"/a/", "/b/"},
+		},
+		{
+			name:       "longest match wins",
+			body:       "User-agent: *\nDisallow: /p\nAllow: /page\n",
+			allowed:    []string{"/page", "/pages/1"},
+			disallowed: []string{"/p", "/pa"},
+		},
+		{
+			name:       "longest disallow wins",
+			body:       "User-agent: *\nAllow: /folder\nDisallow: /folder/private\n",
+			allowed:    []string{"/folder/public"},
+			disallowed: []string{"/folder/private/x"},
+		},
+		{
+			name:    "allow wins a tie",
+			body:    "User-agent: *\nDisallow: /page\nAllow: /page\n",
+			allowed: []string{"/page"},
+		},
+		{
+			name:       "only the front page",
+			body:       "User-agent: *\nAllow: /$\nDisallow: /\n",
+			allowed:    []string{"/"},
+			disallowed: []string{"/index.html"},
+		},
+		{
+			name:       "comments and case",
+			body:       "# hello\nUSER-AGENT: * # everyone\ndisallow: /x # not x\nDisallow:\n",
+			allowed:    []string{"/", "/y"},
+			disallowed: []string{"/x"},
+		},
+		{
+			name:    "robots.txt is always allowed",
+			body:    "User-agent: *\nDisallow: /\n",
+			allowed: []string{"/robots.txt"},
+		},
+	} {
+		rh := &robotshost{rules: parserobots([]byte(test.body), agent)}
+		for _, path := range test.allowed {
+			if !rh.allowed(path) {
+				t.Errorf("%v: %v is disallowed", test.name, path)
+			}
+		}
+		for _, path := range test.disallowed {
+			if rh.allowed(path) {
+				t.Errorf("%v: %v is allowed", test.name, path)
+			}
+		}
+		if rh.delay() != test.delay {
+			t.Errorf("%v: delay %v, want %v", test.name, rh.delay(), test.delay)
+		}
+	}
+}
+
+func TestParserobotsSitemaps(t *testing.T) {
+	rules := parserobots([]byte("Sitemap: https://example.com/a.xml\nUser-agent: googlebot\nSitemap: https://example.com/b.xml\n"), "turbograb")
+	if !slices.Equal(rules.sitemaps, []string{"https://example.com/a.xml", "https://example.com/b.xml"}) {
+		t.Errorf("sitemaps %v", rules.sitemaps)
+	}
+}
+
+func TestRobotsRedirect(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		io.WriteString(w, "<html>front page</html>")
+	}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+	config := &tls.Config{InsecureSkipVerify: true}
+
+	sess := newSession(&dialer{timeout: 5 * time.Second}, &tracer{}, 5*time.Second, 65536)
+	sess.robots = newRobotscache()
+	done := make(chan int)
+	go func() {
+		rh := sess.robots.host("https://"+host, func() *robotsrules {
+			return fetchrobots(sess, "https", host, config, "turbograb", 5)
+		})
+		if rh.rules == nil {
+			t.Error("no rules from the redirected robots.txt")
+		}
+		code, _, err := sess.get(server.URL+"/", config, "turbograb", 5)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- code
+	}()
+
+	select {
+	case code := <-done:
+		if code != 200 {
+			t.Errorf("front page code %v", code)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("fetching a redirected robots.txt hangs")
+	}
+}
+
+func TestRobotscacheSweep(t *testing.T) {
+	rc := newRobotscache()
+	fetches := 0
+	fetch := func() *robotsrules {
+		fetches++
+		return &robotsrules{}
+	}
+	rc.host("https://old", fetch).fetched = time.Now().Add(-2 * robotsmaxage)
+	rc.host("https://new", fetch)
+	rc.host("https://old", fetch)
+	if fetches != 2 {
+		t.Fatalf("%v fetches before sweeping", fetches)
+	}
+
+	rc.sweep()
+	if _, found := rc.hosts["https://old"]; found {
+		t.Error("old host was kept")
+	}
+	if _, found := rc.hosts["https://new"]; !found {
+		t.Error("new host was dropped")
+	}
+	rc.host("https://old", fetch)
+	if fetches != 3 {
+		t.Errorf("%v fetches after sweeping", fetches)
+	}
+}
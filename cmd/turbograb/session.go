@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/lkarlslund/turbograb"
+	"github.com/valyala/fasthttp"
+)
+
+// session owns the connection for a single producer. A connection is only kept open
+// for the next request if neither side asked for it to be closed, and the next request
+// goes to the same address with the same TLS config. Otherwise it's closed right away,
+// so there are no idle connections lingering in the background.
+type session struct {
+	dialer     *dialer
+	trace      *tracer
+	extratrace *tracer // for get, so extra files don't count towards the timings of the page
+	timeout    time.Duration
+	maxbody    int
+	robots     *robotscache // nil unless get should respect robots.txt
+
+	conn      net.Conn
+	traced    *tracedConn
+	addr      string
+	tlsconfig *tls.Config
+	raddr     string
+	tlsinfo   *turbograb.TLSInfo
+
+	br       *bufio.Reader
+	bw       *bufio.Writer
+	received *countingReader
+}
+
+// countingReader counts what's read from the connection, to tell if the server answered at all
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(b []byte) (int, error) {
+	n, err := cr.Reader.Read(b)
+	cr.n += n
+	return n, err
+}
+
+func newSession(d *dialer, t *tracer, timeout time.Duration, maxbody int) *session {
+	return &session{
+		dialer:     d,
+		trace:      t,
+		extratrace: &tracer{},
+		timeout:    timeout,
+		maxbody:    maxbody,
+		br:         bufio.NewReaderSize(nil, 4096),
+		bw:         bufio.NewWriterSize(nil, 4096),
+	}
+}
+
+// do sends req to addr and reads the reply into resp, tlsconfig is nil for plain HTTP
+func (s *session) do(addr string, tlsconfig *tls.Config, req *fasthttp.Request, resp *fasthttp.Response) error {
+	if s.conn != nil && (s.addr != addr || s.tlsconfig != tlsconfig) {
+		s.close()
+	}
+
+	reused := s.conn != nil
+	if !reused {
+		s.raddr = ""
+		s.tlsinfo = nil
+		conn, err := s.dialer.dial(s.trace, addr, tlsconfig)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		s.traced = s.trace.conn
+		s.addr = addr
+		s.tlsconfig = tlsconfig
+		s.raddr = conn.RemoteAddr().String()
+		if tlsconn, ok := conn.(*tls.Conn); ok {
+			s.tlsinfo = turbograb.NewTLSInfo(tlsconn.ConnectionState())
+		}
+		s.received = &countingReader{Reader: conn}
+		s.br.Reset(s.received)
+		s.bw.Reset(conn)
+	}
+
+	if reused {
+		// Dialing waited for the rate limits already
+		host, _, _ := net.SplitHostPort(addr)
+		var ip net.IP
+		if tcpaddr, ok := s.conn.RemoteAddr().(*net.TCPAddr); ok {
+			ip = tcpaddr.IP
+		}
+		if err := s.dialer.limits.request(host, ip, time.Now().Add(s.timeout)); err != nil {
+			return err
+		}
+	}
+
+	// The connection can have been made for the other tracer
+	s.trace.conn = s.traced
+	s.conn.SetDeadline(time.Now().Add(s.timeout))
+	s.received.n = 0
+
+	err := req.Write(s.bw)
+	if err == nil {
+		err = s.bw.Flush()
+	}
+	if err == nil {
+		err = resp.ReadLimitBody(s.br, s.maxbody)
+	}
+
+	if err != nil || req.ConnectionClose() || resp.ConnectionClose() {
+		s.close()
+	}
+
+	var neterr net.Error
+	timeout := errors.As(err, &neterr) && neterr.Timeout()
+	if err != nil && reused && !timeout && s.received.n == 0 {
+		// The server closed the kept-alive connection before we asked, that's not worth a retry
+		return s.do(addr, tlsconfig, req, resp)
+	}
+	if timeout {
+		return fasthttp.ErrTimeout
+	}
+	if err == io.EOF {
+		return fasthttp.ErrConnectionClosed
+	}
+	return err
+}
+
+// remoteaddr returns the address of the last connection made, or blank if dialing failed
+func (s *session) remoteaddr() string {
+	return s.raddr
+}
+
+// tlsdetails returns the TLS session details for the last connection made, or nil if it didn't use TLS
+func (s *session) tlsdetails() *turbograb.TLSInfo {
+	return s.tlsinfo
+}
+
+// close closes the connection if there is one
+func (s *session) close() {
+	if s.conn == nil {
+		return
+	}
+	s.conn.Close()
+	s.conn = nil
+	s.tlsconfig = nil
+	s.br.Reset(nil)
+	s.bw.Reset(nil)
+}
+
+// get fetches rawurl and follows redirects, for the extra files grabbed along with a site like robots.txt and sitemaps
+func (s *session) get(rawurl string, tlsconfig *tls.Config, agent string, maxredirects int) (int, []byte, error) {
+	return s.fetch(rawurl, tlsconfig, agent, maxredirects, s.robots)
+}
+
+// fetch is get with the robots.txt rules to follow, nil when fetching robots.txt itself as it's not ready yet
+func (s *session) fetch(rawurl string, tlsconfig *tls.Config, agent string, maxredirects int, robots *robotscache) (int, []byte, error) {
+	pagetrace := s.trace
+	s.trace = s.extratrace
+	defer func() {
+		s.trace = pagetrace
+	}()
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	for redirects := 0; redirects <= maxredirects; redirects++ {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return 0, nil, err
+		}
+		var addr string
+		var conntls *tls.Config
+		switch u.Scheme {
+		case "https":
+			addr = u.Host + ":443"
+			conntls = tlsconfig
+		case "http":
+			addr = u.Host + ":80"
+		default:
+			return 0, nil, fmt.Errorf("unsupported scheme in %v", rawurl)
+		}
+		if u.Port() != "" {
+			addr = u.Host
+		}
+		if conntls != nil && s.conn != nil && s.addr == addr && s.tlsconfig != nil {
+			// Any TLS connection to the host will do, and the page's one is kept open for the next page
+			conntls = s.tlsconfig
+		}
+
+		if robots != nil {
+			rh := robots.host(u.Scheme+"://"+u.Host, func() *robotsrules {
+				return fetchrobots(s, u.Scheme, u.Host, tlsconfig, agent, maxredirects)
+			})
+			if !rh.allowed(u.RequestURI()) {
+				return 0, nil, errRobotsDisallowed
+			}
+			rh.wait()
+		}
+
+		req.Reset()
+		resp.Reset()
+		req.SetRequestURI(rawurl)
+		req.Header.SetUserAgent(agent)
+		s.trace.begin()
+		err = s.do(addr, conntls, req, resp)
+		s.trace.end()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		code := resp.StatusCode()
+		if !fasthttp.StatusCodeIsRedirect(code) {
+			return code, append([]byte(nil), resp.Body()...), nil
+		}
+		location, err := url.Parse(string(resp.Header.Peek("Location")))
+		if err != nil {
+			return code, nil, err
+		}
+		rawurl = u.ResolveReference(location).String()
+	}
+	return 0, nil, fasthttp.ErrTooManyRedirects
+}
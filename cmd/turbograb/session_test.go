@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// benchserver serves /self, which redirects to /fast on the same connection, and /fast
+func benchserver(b *testing.B) (string, *tls.Config) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/self" {
+			http.Redirect(w, r, "/fast", http.StatusFound)
+			return
+		}
+		io.WriteString(w, "fast")
+	}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	b.Cleanup(server.Close)
+	return server.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true}
+}
+
+func TestSessionStaleConnection(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "fast")
+	}))
+	server.Config.IdleTimeout = 50 * time.Millisecond
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	addr, config := server.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true}
+
+	sess := newSession(&dialer{timeout: 5 * time.Second}, &tracer{}, 5*time.Second, 65536)
+	defer sess.close()
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	req.SetRequestURI("https://" + addr + "/fast")
+	for i := 0; i < 2; i++ {
+		if err := sess.do(addr, config, req, resp); err != nil {
+			t.Fatalf("request %v: %v", i, err)
+		}
+		if string(resp.Body()) != "fast" {
+			t.Fatalf("request %v: body %q", i, resp.Body())
+		}
+		// The server closes the idle connection in the meantime
+		time.Sleep(200 * time.Millisecond)
+	}
+	if conns.Load() != 2 {
+		t.Errorf("%v connections, want 2", conns.Load())
+	}
+}
+
+func TestSessionResumption(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "fast")
+	}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+	var certs []*x509.Certificate
+	config := &tls.Config{
+		InsecureSkipVerify:    true,
+		ClientSessionCache:    tls.NewLRUClientSessionCache(0),
+		VerifyPeerCertificate: storecertinfo(&certs),
+	}
+
+	sess := newSession(&dialer{timeout: 5 * time.Second}, &tracer{}, 5*time.Second, 65536)
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	req.SetRequestURI("https://" + addr + "/fast")
+	for i, resumed := range []bool{false, true} {
+		certs = nil
+		if err := sess.do(addr, config, req, resp); err != nil {
+			t.Fatalf("connection %v: %v", i, err)
+		}
+		if sess.tlsinfo == nil || sess.tlsinfo.Resumed != resumed {
+			t.Errorf("connection %v: TLS %+v, want resumed %v", i, sess.tlsinfo, resumed)
+		}
+		if len(certs) == 0 {
+			t.Errorf("connection %v: no certificates stored", i)
+		}
+		sess.close()
+	}
+}
+
+// BenchmarkSession grabs one site per iteration the way the producers do, a redirect followed on the
+// same connection, which is then closed before the next site
+func BenchmarkSession(b *testing.B) {
+	addr, config := benchserver(b)
+	trace := &tracer{}
+	sess := newSession(&dialer{timeout: 5 * time.Second}, trace, 5*time.Second, 65536)
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range []string{"/self", "/fast"} {
+			req.Reset()
+			resp.Reset()
+			req.SetRequestURI("https://" + addr + path)
+			trace.begin()
+			if err := sess.do(addr, config, req, resp); err != nil {
+				b.Fatal(err)
+			}
+			trace.end()
+		}
+		sess.close()
+	}
+}
+
+// BenchmarkHostClient is the same as BenchmarkSession with a HostClient per site, like producers did
+// before the session. It leaves out the two second sleep after CloseIdleConnections they also did.
+func BenchmarkHostClient(b *testing.B) {
+	addr, config := benchserver(b)
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hostclient := &fasthttp.HostClient{
+			Addr:                     addr,
+			IsTLS:                    true,
+			TLSConfig:                config,
+			NoDefaultUserAgentHeader: true,
+			MaxResponseBodySize:      65536,
+			WriteTimeout:             5 * time.Second,
+			ReadTimeout:              5 * time.Second,
+			MaxConns:                 1,
+			MaxIdleConnDuration:      time.Millisecond * 1100,
+		}
+		for _, path := range []string{"/self", "/fast"} {
+			req.Reset()
+			resp.Reset()
+			req.SetRequestURI("https://" + addr + path)
+			if err := hostclient.Do(req, resp); err != nil {
+				b.Fatal(err)
+			}
+		}
+		hostclient.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// maxsitemaps is how many sitemap files are fetched for one site, indexes included
+const maxsitemaps = 50
+
+// sitemapxml is either a urlset or a sitemapindex, the root element isn't checked
+type sitemapxml struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// sitemapurls fetches the sitemaps of the site at base, starting with the ones robots.txt refers to and
+// /sitemap.xml, and returns up to limit page URLs on the same host from them
+func sitemapurls(sess *session, base *url.URL, refs []string, tlsconfig *tls.Config, agent string, maxredirects, maxsize, limit int) []*url.URL {
+	queue := append(slices.Clone(refs), base.Scheme+"://"+base.Host+"/sitemap.xml")
+	seensitemaps := make(map[string]struct{})
+	seenpages := make(map[string]struct{})
+
+	var result []*url.URL
+	var fetched int
+	for len(queue) > 0 && len(result) < limit && fetched < maxsitemaps {
+		sitemapurl := strings.TrimSpace(queue[0])
+		queue = queue[1:]
+		if _, found := seensitemaps[sitemapurl]; found {
+			continue
+		}
+		seensitemaps[sitemapurl] = struct{}{}
+
+		fetched++
+		code, body, err := sess.get(sitemapurl, tlsconfig, agent, maxredirects)
+		if err != nil || code < 200 || code > 299 {
+			continue
+		}
+		locs, sitemaps := parsesitemap(body, maxsize)
+		queue = append(queue, sitemaps...)
+
+		for _, loc := range locs {
+			page, err := url.Parse(strings.TrimSpace(loc))
+			if err != nil || normalizename(page.Host) != normalizename(base.Host) {
+				continue
+			}
+			page.Fragment = ""
+			key := crawlkey(page)
+			if _, found := seenpages[key]; found || key == "" {
+				continue
+			}
+			seenpages[key] = struct{}{}
+			result = append(result, page)
+			if len(result) == limit {
+				break
+			}
+		}
+	}
+	return result
+}
+
+// parsesitemap returns the page and sitemap URLs in a sitemap, which can be gzipped XML or a plain list of URLs
+func parsesitemap(body []byte, maxsize int) (locs, sitemaps []string) {
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, nil
+		}
+		body, err = io.ReadAll(io.LimitReader(gz, int64(maxsize)))
+		if err != nil && len(body) == 0 {
+			return nil, nil
+		}
+	}
+
+	var sm sitemapxml
+	if err := xml.Unmarshal(body, &sm); err == nil {
+		for _, u := range sm.URLs {
+			locs = append(locs, u.Loc)
+		}
+		for _, s := range sm.Sitemaps {
+			sitemaps = append(sitemaps, s.Loc)
+		}
+		return locs, sitemaps
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+			locs = append(locs, line)
+		}
+	}
+	return locs, nil
+}
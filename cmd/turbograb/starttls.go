@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lkarlslund/turbograb"
+)
+
+// maxbanner is how much we read from a server before giving up on it ever saying STARTTLS is fine
+const maxbanner = 16384
+
+var errNoStartTLS = errors.New("server refused STARTTLS")
+
+// starttlsupgrader talks the plaintext part of a protocol until the server is ready for the TLS handshake,
+// everything the server says is kept in banner
+type starttlsupgrader func(rw *bufio.ReadWriter, host string, banner *bytes.Buffer) error
+
+// starttlsprotocols are the protocols we can grab certificates from, with their default port
+var starttlsprotocols = map[string]struct {
+	port    string
+	upgrade starttlsupgrader
+}{
+	"smtp":       {"25", smtpstarttls},
+	"submission": {"587", smtpstarttls},
+	"imap":       {"143", imapstarttls},
+	"pop3":       {"110", pop3starttls},
+	"ftp":        {"21", ftpstarttls},
+	"xmpp":       {"5222", xmppstarttls},
+}
+
+// starttls connects to site on the default port for protocol, unless site has a port, and grabs
+// the banner and the certificate chain after upgrading the connection
+func (g *tlsgrabber) starttls(site, protocol string, certs *[]*x509.Certificate) turbograb.Result {
+	result := turbograb.Result{
+		Site:     site,
+		Protocol: protocol,
+	}
+
+	proto := starttlsprotocols[protocol]
+	host, port := site, proto.port
+	if h, p, err := net.SplitHostPort(site); err == nil {
+		host, port = h, p
+	}
+	addr := net.JoinHostPort(host, port)
+
+	// Whatever the server says about ALPN is meaningless here
+	config := g.config.Clone()
+	config.NextProtos = nil
+
+	var err error
+	retries := max(g.retries, 1) // always make one attempt
+	for retriesleft := retries; retriesleft > 0; {
+		wait := g.policy.delay(retries - retriesleft)
+
+		var banner bytes.Buffer
+		var conn net.Conn
+
+		g.trace.begin()
+		conn, err = g.dialer.dial(g.trace, addr, nil)
+		if err == nil {
+			result.IPaddress = conn.RemoteAddr().String()
+			conn.SetDeadline(time.Now().Add(g.timeout))
+			rw := bufio.NewReadWriter(bufio.NewReader(io.LimitReader(conn, maxbanner)), bufio.NewWriter(conn))
+			err = proto.upgrade(rw, host, &banner)
+			result.Banner = banner.String()
+			if err == nil {
+				conn, err = g.dialer.handshake(g.trace, conn, host, config)
+			}
+			if err != nil && conn != nil {
+				conn.Close()
+			}
+		}
+		g.trace.end()
+		g.throttle.observe(err)
+
+		if err == nil {
+			result.TLS = turbograb.NewTLSInfo(conn.(*tls.Conn).ConnectionState())
+			conn.Close()
+			if verr := verifychain(*certs, host); verr != nil {
+				result.Warnings = append(result.Warnings, verifywarning(verr))
+			}
+			result.Certificates = *certs
+			result.Validation = g.validator.validate(*certs, host, time.Now())
+			return result
+		}
+
+		if err == errPolitenessWait {
+			continue // try again, but it doesn't cost a retry
+		} else if _, ok := err.(*net.DNSError); ok {
+			break
+		} else if errors.Is(err, syscall.ECONNREFUSED) {
+			result.Warnings = append(result.Warnings, "connection_refused")
+			break
+		} else if errors.Is(err, errNoStartTLS) {
+			result.Warnings = append(result.Warnings, "starttls_refused")
+			break
+		} else if strings.HasPrefix(err.Error(), "remote error: tls:") || strings.HasPrefix(err.Error(), "tls:") {
+			break
+		}
+
+		if g.showerrors {
+			log.Println("STARTTLS with", addr, "error:", err.Error())
+		}
+
+		// No point in waiting after the last attempt
+		if retriesleft > 1 {
+			time.Sleep(wait)
+		}
+		retriesleft--
+	}
+
+	result.Error = err.Error()
+	return result
+}
+
+// command sends a line to the server
+func command(rw *bufio.ReadWriter, line string) error {
+	rw.WriteString(line)
+	rw.WriteString("\r\n")
+	return rw.Flush()
+}
+
+// readline reads a single line from the server, and adds it to the banner
+func readline(rw *bufio.ReadWriter, banner *bytes.Buffer) (string, error) {
+	line, err := rw.ReadString('\n')
+	banner.WriteString(line)
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// readreply reads a possibly multiline SMTP or FTP reply and returns the reply code
+func readreply(rw *bufio.ReadWriter, banner *bytes.Buffer) (string, error) {
+	for {
+		line, err := readline(rw, banner)
+		if err != nil {
+			return "", err
+		}
+		if len(line) < 3 {
+			return "", fmt.Errorf("%w: unexpected reply %q", errNoStartTLS, line)
+		}
+		if len(line) == 3 || line[3] != '-' {
+			return line[:3], nil
+		}
+	}
+}
+
+// expectreply reads a reply and fails if it doesn't have the code we want
+func expectreply(rw *bufio.ReadWriter, banner *bytes.Buffer, code string) error {
+	got, err := readreply(rw, banner)
+	if err != nil {
+		return err
+	}
+	if got != code {
+		return fmt.Errorf("%w: got reply code %v, expected %v", errNoStartTLS, got, code)
+	}
+	return nil
+}
+
+func smtpstarttls(rw *bufio.ReadWriter, host string, banner *bytes.Buffer) error {
+	if err := expectreply(rw, banner, "220"); err != nil {
+		return err
+	}
+	if err := command(rw, "EHLO turbograb"); err != nil {
+		return err
+	}
+	start := banner.Len()
+	if err := expectreply(rw, banner, "250"); err != nil {
+		return err
+	}
+	if !strings.Contains(strings.ToUpper(banner.String()[start:]), "STARTTLS") {
+		return fmt.Errorf("%w: not in EHLO reply", errNoStartTLS)
+	}
+	if err := command(rw, "STARTTLS"); err != nil {
+		return err
+	}
+	return expectreply(rw, banner, "220")
+}
+
+func ftpstarttls(rw *bufio.ReadWriter, host string, banner *bytes.Buffer) error {
+	if err := expectreply(rw, banner, "220"); err != nil {
+		return err
+	}
+	if err := command(rw, "AUTH TLS"); err != nil {
+		return err
+	}
+	return expectreply(rw, banner, "234")
+}
+
+func imapstarttls(rw *bufio.ReadWriter, host string, banner *bytes.Buffer) error {
+	line, err := readline(rw, banner)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "* OK") {
+		return fmt.Errorf("%w: unexpected greeting %q", errNoStartTLS, line)
+	}
+	if err = command(rw, "a1 STARTTLS"); err != nil {
+		return err
+	}
+	// Skip any untagged responses
+	for !strings.HasPrefix(line, "a1 ") {
+		if line, err = readline(rw, banner); err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(line, "a1 OK") {
+		return fmt.Errorf("%w: %v", errNoStartTLS, line)
+	}
+	return nil
+}
+
+func pop3starttls(rw *bufio.ReadWriter, host string, banner *bytes.Buffer) error {
+	line, err := readline(rw, banner)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("%w: unexpected greeting %q", errNoStartTLS, line)
+	}
+	if err = command(rw, "STLS"); err != nil {
+		return err
+	}
+	if line, err = readline(rw, banner); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("%w: %v", errNoStartTLS, line)
+	}
+	return nil
+}
+
+func xmppstarttls(rw *bufio.ReadWriter, host string, banner *bytes.Buffer) error {
+	rw.WriteString("<?xml version='1.0'?><stream:stream to='" + host + "' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>")
+	if err := rw.Flush(); err != nil {
+		return err
+	}
+	// Read a tag at a time, servers with nothing to offer send an empty <stream:features/>
+	for {
+		if err := readuntil(rw, banner, ">"); err != nil {
+			return err
+		}
+		received := banner.String()
+		tag := received[max(strings.LastIndex(received, "<"), 0):]
+		if tag == "</stream:features>" || strings.HasPrefix(tag, "<stream:features") && strings.HasSuffix(tag, "/>") {
+			break
+		}
+	}
+	if !strings.Contains(banner.String(), "<starttls") {
+		return fmt.Errorf("%w: not in stream features", errNoStartTLS)
+	}
+	rw.WriteString("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+	if err := rw.Flush(); err != nil {
+		return err
+	}
+	start := banner.Len()
+	if err := readuntil(rw, banner, ">"); err != nil {
+		return err
+	}
+	if !strings.Contains(banner.String()[start:], "<proceed") {
+		return fmt.Errorf("%w: %v", errNoStartTLS, banner.String()[start:])
+	}
+	return nil
+}
+
+// readuntil reads from the server into the banner until what it read ends with marker
+func readuntil(rw *bufio.ReadWriter, banner *bytes.Buffer, marker string) error {
+	start := banner.Len()
+	for !bytes.HasSuffix(banner.Bytes()[start:], []byte(marker)) {
+		b, err := rw.ReadByte()
+		if err != nil {
+			return err
+		}
+		banner.WriteByte(b)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXmppstarttls(t *testing.T) {
+	const stream = "<?xml version='1.0'?><stream:stream from='example.com' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>"
+	for _, test := range []struct {
+		name     string
+		features string
+		answer   string
+		err      error
+	}{
+		{"offered", "<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls></stream:features>", "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>", nil},
+		{"not offered", "<stream:features><mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms></stream:features>", "", errNoStartTLS},
+		{"empty features", "<stream:features/>", "", errNoStartTLS},
+		{"empty features with space", "<stream:features />", "", errNoStartTLS},
+		{"refused", "<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/></stream:features>", "<failure xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>", errNoStartTLS},
+	} {
+		client, server := net.Pipe()
+		client.SetDeadline(time.Now().Add(2 * time.Second))
+		go func(features, answer string) {
+			defer server.Close()
+			br := bufio.NewReader(server)
+			if _, err := br.ReadString('>'); err != nil { // the XML declaration
+				return
+			}
+			if _, err := br.ReadString('>'); err != nil {
+				return
+			}
+			io.WriteString(server, stream+features)
+			if answer != "" {
+				if _, err := br.ReadString('>'); err == nil {
+					io.WriteString(server, answer)
+				}
+			}
+		}(test.features, test.answer)
+
+		var banner bytes.Buffer
+		err := xmppstarttls(bufio.NewReadWriter(bufio.NewReader(client), bufio.NewWriter(client)), "example.com", &banner)
+		client.Close()
+		if test.err == nil && err != nil || test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%v: got error %v, want %v", test.name, err, test.err)
+		}
+		if !strings.Contains(banner.String(), test.features) {
+			t.Errorf("%v: banner %q", test.name, banner.String())
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/tls"
+	_ "embed"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/lkarlslund/turbograb"
+	"github.com/spf13/pflag"
+)
+
+// defaulttechrules are used when no rules file is given
+//
+//go:embed techrules.json
+var defaulttechrules []byte
+
+// loadtechrules reads the rules file, or the built in rules if filename is blank
+func loadtechrules(filename string) *turbograb.TechRules {
+	var rules *turbograb.TechRules
+	var err error
+	if filename == "" {
+		rules, err = turbograb.ParseTechRules(defaulttechrules)
+	} else {
+		rules, err = turbograb.LoadTechRules(filename)
+	}
+	if err != nil {
+		log.Println("Error loading technology rules:", err)
+		os.Exit(1)
+	}
+	for _, skipped := range rules.Skipped {
+		log.Println("Skipping technology rule pattern", skipped)
+	}
+	return rules
+}
+
+// fetchfavicon returns the MD5 of the favicon in hex, or blank if the site doesn't have one
+func fetchfavicon(sess *session, pageurl, iconurl string, tlsconfig *tls.Config, agent string, maxredirects int) string {
+	base, err := url.Parse(pageurl)
+	if err != nil {
+		return ""
+	}
+	if iconurl == "" {
+		iconurl = "/favicon.ico"
+	}
+	icon, err := url.Parse(iconurl)
+	if err != nil {
+		return ""
+	}
+	code, body, err := sess.get(base.ResolveReference(icon).String(), tlsconfig, agent, maxredirects)
+	if err != nil || code != 200 || len(body) == 0 || ishtml(body) {
+		return ""
+	}
+	hash := md5.Sum(body)
+	return hex.EncodeToString(hash[:])
+}
+
+// technologiescommand detects technologies in results that were grabbed already
+func technologiescommand(args []string) {
+	flags := pflag.NewFlagSet("technologies", pflag.ExitOnError)
+	input := flags.String("input", "*.json", "Result files to read (JSON format, optionally LZ4 compressed)")
+	techrules := flags.String("techrules", "", "Technology rules file (default is the built in rules)")
+	format := flags.String("format", "csv", "Output format (csv, json)")
+	output := flags.String("output", "", "Output file (default is stdout)")
+	flags.Parse(args)
+
+	switch *format {
+	case "csv", "json":
+	default:
+		log.Println("Unknown format", *format)
+		os.Exit(1)
+	}
+
+	rules := loadtechrules(*techrules)
+
+	files, err := filepath.Glob(*input)
+	if err != nil {
+		log.Println("Error locating files to process:", err)
+		os.Exit(1)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		outfile, err := os.Create(*output)
+		if err != nil {
+			log.Println("Error creating output file:", err)
+			os.Exit(1)
+		}
+		defer outfile.Close()
+		out = outfile
+	}
+	bw := bufio.NewWriter(out)
+	defer bw.Flush()
+
+	cw := csv.NewWriter(bw)
+	encoder := json.NewEncoder(bw)
+	if *format == "csv" {
+		cw.Write([]string{"site", "url", "technology", "version", "confidence"})
+	}
+
+	for _, file := range files {
+		reader, err := turbograb.OpenFile(file)
+		if err != nil {
+			log.Printf("Error opening %v: %v", file, err)
+			continue
+		}
+		for {
+			result, err := reader.Read()
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("Error reading %v: %v", file, err)
+				}
+				break
+			}
+			if result.Error != "" {
+				continue
+			}
+			technologies := rules.Detect(&result)
+			if len(technologies) == 0 {
+				continue
+			}
+			if *format == "json" {
+				encoder.Encode(struct {
+					Site         string                 `json:"site"`
+					URL          string                 `json:"url"`
+					Technologies []turbograb.Technology `json:"technologies"`
+				}{result.Site, result.URL, technologies})
+				continue
+			}
+			for _, t := range technologies {
+				cw.Write([]string{result.Site, result.URL, t.Name, t.Version, strconv.Itoa(t.Confidence)})
+			}
+		}
+		reader.Close()
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Println("Error writing output:", err)
+		os.Exit(1)
+	}
+}
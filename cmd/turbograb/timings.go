@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lkarlslund/turbograb"
+)
+
+// tracer keeps track of the timings for a single producer, the dialer fills in DNS, connect and
+// TLS handshake, and the connection is watched for time to first byte
+type tracer struct {
+	timings   turbograb.Timings
+	conn      *tracedConn
+	sitestart time.Time
+}
+
+// reset is called before grabbing a new site
+func (t *tracer) reset() {
+	t.timings = turbograb.Timings{}
+	t.sitestart = time.Now()
+}
+
+// begin is called before every attempt
+func (t *tracer) begin() {
+	t.timings.DNS = 0
+	t.timings.Connect = 0
+	t.timings.TLSHandshake = 0
+	t.timings.FirstByte = 0
+	t.timings.Transfer = 0
+	t.timings.Attempts++
+	if t.conn != nil {
+		t.conn.wrote = time.Time{}
+		t.conn.read = time.Time{}
+	}
+}
+
+// end is called after every attempt
+func (t *tracer) end() {
+	if t.conn == nil || t.conn.read.IsZero() {
+		return
+	}
+	t.timings.FirstByte = t.conn.read.Sub(t.conn.wrote)
+	t.timings.Transfer = time.Since(t.conn.read)
+}
+
+// result returns the timings for the site grabbed since the last reset
+func (t *tracer) result() *turbograb.Timings {
+	timings := t.timings
+	timings.Total = time.Since(t.sitestart)
+	return &timings
+}
+
+// tracedConn remembers when the request started going out and when the response started coming in
+type tracedConn struct {
+	net.Conn
+	wrote, read time.Time
+}
+
+func (c *tracedConn) Write(b []byte) (int, error) {
+	if c.wrote.IsZero() {
+		c.wrote = time.Now()
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *tracedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.read.IsZero() && !c.wrote.IsZero() {
+		c.read = time.Now()
+	}
+	return n, err
+}
+
+var phasenames = [...]string{"DNS", "Connect", "TLS handshake", "First byte", "Transfer", "Total"}
+
+func phases(t *turbograb.Timings) [len(phasenames)]time.Duration {
+	return [...]time.Duration{t.DNS, t.Connect, t.TLSHandshake, t.FirstByte, t.Transfer, t.Total}
+}
+
+type phasestats struct {
+	count      int
+	total, max time.Duration
+}
+
+// timingstats collects timings from all producers for the end of run report
+type timingstats struct {
+	lock     sync.Mutex
+	results  int
+	attempts int
+	phases   [len(phasenames)]phasestats
+}
+
+func (ts *timingstats) add(t *turbograb.Timings) {
+	ts.lock.Lock()
+	ts.results++
+	ts.attempts += t.Attempts
+	for i, d := range phases(t) {
+		if d == 0 {
+			continue
+		}
+		ps := &ts.phases[i]
+		ps.count++
+		ps.total += d
+		if d > ps.max {
+			ps.max = d
+		}
+	}
+	ts.lock.Unlock()
+}
+
+func (ts *timingstats) report(w io.Writer) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	if ts.results == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "Timings for %v sites, %.2f attempts per site on average\n", ts.results, float64(ts.attempts)/float64(ts.results))
+	for i, ps := range ts.phases {
+		if ps.count == 0 {
+			continue
+		}
+		avg := ps.total / time.Duration(ps.count)
+		fmt.Fprintf(w, "  %-14s avg %-12v max %-12v (%v samples)\n", phasenames[i], avg.Round(time.Millisecond), ps.max.Round(time.Millisecond), ps.count)
+	}
+}
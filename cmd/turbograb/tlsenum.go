@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lkarlslund/turbograb"
+)
+
+// tlsversions are the protocol versions we can offer, SSLv3 and older aren't implemented by crypto/tls
+var tlsversions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// tlsversion parses a version like 1.2, blank gives 0 which means the crypto/tls default
+func tlsversion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %v", version)
+}
+
+// enumerate grabs the chain like grab does, and then finds out which protocol versions
+// and cipher suites the site accepts by offering them one by one
+func (g *tlsgrabber) enumerate(site string, certs *[]*x509.Certificate) turbograb.Result {
+	result := g.grab(site, certs)
+	if result.Error != "" {
+		return result
+	}
+
+	host, port := site, "443"
+	if h, p, err := net.SplitHostPort(site); err == nil {
+		host, port = h, p
+	}
+	if slices.Contains(result.Warnings, "prefix_www") {
+		host = "www." + host
+	}
+	addr := net.JoinHostPort(host, port)
+
+	insecure := make(map[uint16]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.ID] = true
+	}
+
+	for _, version := range tlsversions {
+		// Versions outside --tls-min and --tls-max are not tested at all
+		if (g.config.MinVersion != 0 && version < g.config.MinVersion) || (g.config.MaxVersion != 0 && version > g.config.MaxVersion) {
+			continue
+		}
+
+		support := turbograb.TLSSupport{
+			Version: tls.VersionName(version),
+		}
+
+		if version == tls.VersionTLS13 {
+			// crypto/tls won't let us choose TLS 1.3 suites, so we only get the one the server prefers
+			if suite, err := g.offer(addr, version, nil); err == nil {
+				support.Accepted = true
+				support.CipherSuites = append(support.CipherSuites, tls.CipherSuiteName(suite))
+			}
+		} else {
+			// The server picks one of the suites we offer, so keep taking that one away until it gives up
+			offered := suitesfor(version)
+			for len(offered) > 0 {
+				suite, err := g.offer(addr, version, offered)
+				if err != nil {
+					break
+				}
+				support.Accepted = true
+				support.CipherSuites = append(support.CipherSuites, tls.CipherSuiteName(suite))
+				if insecure[suite] {
+					result.Warnings = append(result.Warnings, "tls_weak_cipher")
+				}
+				offered = slices.DeleteFunc(offered, func(id uint16) bool {
+					return id == suite
+				})
+			}
+		}
+
+		if support.Accepted && version < tls.VersionTLS12 {
+			result.Warnings = append(result.Warnings, "tls_weak_version")
+		}
+
+		result.TLSSupport = append(result.TLSSupport, support)
+	}
+
+	return result
+}
+
+// offer does a handshake with addr allowing only version and suites, and returns the suite the server picked
+func (g *tlsgrabber) offer(addr string, version uint16, suites []uint16) (uint16, error) {
+	config := g.config.Clone()
+	config.MinVersion = version
+	config.MaxVersion = version
+	config.CipherSuites = suites
+	config.VerifyPeerCertificate = nil // grab already has the chain
+
+	t := &tracer{} // timings for the probes are not interesting
+	var err error
+	retries := max(g.retries, 1) // always make one attempt
+	for retriesleft := retries; retriesleft > 0; {
+		wait := g.policy.delay(retries - retriesleft)
+
+		var conn net.Conn
+		conn, err = g.dialer.dial(t, addr, config)
+		if err == nil {
+			state := conn.(*tls.Conn).ConnectionState()
+			conn.Close()
+			return state.CipherSuite, nil
+		}
+
+		if err == errPolitenessWait {
+			continue
+		}
+		if tlsrejected(err) {
+			break
+		}
+		g.throttle.observe(err)
+
+		// No point in waiting after the last attempt
+		if retriesleft > 1 {
+			time.Sleep(wait)
+		}
+		retriesleft--
+	}
+	return 0, err
+}
+
+// suitesfor returns every cipher suite crypto/tls can offer for version, including the insecure ones
+func suitesfor(version uint16) []uint16 {
+	var suites []uint16
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if slices.Contains(suite.SupportedVersions, version) {
+			suites = append(suites, suite.ID)
+		}
+	}
+	return suites
+}
+
+// tlsrejected tells if err means the server refused the handshake, rather than a network problem
+func tlsrejected(err error) bool {
+	if strings.HasPrefix(err.Error(), "remote error: tls:") || strings.HasPrefix(err.Error(), "tls:") {
+		return true
+	}
+	// Lots of servers just hang up when they don't like what we offered
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lkarlslund/turbograb"
+)
+
+// tlsgrabber only completes a TLS handshake with each site and then hangs up, leaving the
+// certificate chain in the store the VerifyPeerCertificate hook of config writes to
+type tlsgrabber struct {
+	dialer     *dialer
+	trace      *tracer
+	throttle   *adaptive
+	policy     backoff
+	config     *tls.Config // must not verify, so we always get the chain
+	validator  *validator
+	retries    int
+	showerrors bool
+	jarm       bool
+	timeout    time.Duration
+}
+
+// grab does the handshake with site and returns everything but the timings
+func (g *tlsgrabber) grab(site string, certs *[]*x509.Certificate) turbograb.Result {
+	result := turbograb.Result{
+		Site: site,
+	}
+
+	host, port := site, "443"
+	if h, p, err := net.SplitHostPort(site); err == nil {
+		host, port = h, p
+	}
+	var err error
+	retries := max(g.retries, 1) // always make one attempt
+	for retriesleft := retries; retriesleft > 0; {
+		wait := g.policy.delay(retries - retriesleft)
+
+		g.trace.begin()
+		var conn net.Conn
+		conn, err = g.dialer.dial(g.trace, net.JoinHostPort(host, port), g.config)
+		g.trace.end()
+		g.throttle.observe(err)
+
+		if err == nil {
+			result.IPaddress = conn.RemoteAddr().String()
+			result.TLS = turbograb.NewTLSInfo(conn.(*tls.Conn).ConnectionState())
+			conn.Close()
+			if verr := verifychain(*certs, host); verr != nil {
+				result.Warnings = append(result.Warnings, verifywarning(verr))
+			}
+			result.Certificates = *certs
+			result.Validation = g.validator.validate(*certs, host, time.Now())
+			if g.jarm {
+				result.JARM = jarm(g.dialer, host, net.JoinHostPort(host, port), g.timeout)
+			}
+			return result
+		}
+
+		if err == errPolitenessWait {
+			continue // try again, but it doesn't cost a retry
+		} else if _, ok := err.(*net.DNSError); ok {
+			if !strings.HasPrefix(host, "www.") {
+				host = "www." + host
+				result.Warnings = append(result.Warnings, "prefix_www")
+				continue // loop without using a retry
+			}
+			break
+		} else if errors.Is(err, syscall.ECONNREFUSED) {
+			result.Warnings = append(result.Warnings, "connection_refused")
+			break
+		} else if strings.HasPrefix(err.Error(), "remote error: tls:") || strings.HasPrefix(err.Error(), "tls:") {
+			// Server doesn't want to talk TLS with us, retrying won't change that
+			break
+		}
+
+		if g.showerrors {
+			log.Println("Handshake with", host, "error:", err.Error())
+		}
+
+		// No point in waiting after the last attempt
+		if retriesleft > 1 {
+			time.Sleep(wait)
+		}
+		retriesleft--
+	}
+
+	result.Error = err.Error()
+	return result
+}
+
+// verifychain checks the chain against the system roots and the hostname, like the TLS handshake would
+func verifychain(certs []*x509.Certificate, host string) error {
+	if len(certs) == 0 {
+		return errors.New("no certificates")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// verifywarning maps a verification error to the same warnings the HTTP grabber uses
+func verifywarning(err error) string {
+	var hostnameerr x509.HostnameError
+	var authorityerr x509.UnknownAuthorityError
+	var invaliderr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &hostnameerr):
+		return "tls_wrong_host"
+	case errors.As(err, &authorityerr):
+		return "tls_unknown_authority"
+	case errors.As(err, &invaliderr) && invaliderr.Reason == x509.Expired:
+		return "tls_expired_cert"
+	}
+	return "tls_invalid_cert"
+}
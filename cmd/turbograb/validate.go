@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/lkarlslund/turbograb"
+)
+
+// validator checks certificate chains against the system roots, and a custom CA bundle if one is given
+type validator struct {
+	cabundle *x509.CertPool
+}
+
+func newValidator(cabundlefile string) (*validator, error) {
+	v := &validator{}
+	if cabundlefile == "" {
+		return v, nil
+	}
+	pem, err := os.ReadFile(cabundlefile)
+	if err != nil {
+		return nil, err
+	}
+	v.cabundle = x509.NewCertPool()
+	if !v.cabundle.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in CA bundle")
+	}
+	return v, nil
+}
+
+// validate checks the chain presented by host, the leaf certificate must be first
+func (v *validator) validate(certs []*x509.Certificate, host string, now time.Time) *turbograb.Validation {
+	if len(certs) == 0 {
+		return nil
+	}
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	leaf := certs[0]
+	result := &turbograb.Validation{
+		Hostname:    host,
+		NotBefore:   leaf.NotBefore,
+		NotAfter:    leaf.NotAfter,
+		Expired:     now.After(leaf.NotAfter),
+		NotYetValid: now.Before(leaf.NotBefore),
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	if err == nil {
+		result.ChainValid = true
+	} else {
+		result.Errors = append(result.Errors, "system roots: "+err.Error())
+	}
+
+	if v.cabundle != nil {
+		_, err = leaf.Verify(x509.VerifyOptions{
+			Roots:         v.cabundle,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+		})
+		if err == nil {
+			result.CABundleValid = true
+		} else {
+			result.Errors = append(result.Errors, "CA bundle: "+err.Error())
+		}
+	}
+
+	if err = leaf.VerifyHostname(host); err == nil {
+		result.HostnameValid = true
+	} else {
+		result.Errors = append(result.Errors, err.Error())
+	}
+
+	for i, cert := range certs {
+		if weakness := weakkey(cert); weakness != "" {
+			result.WeakKeys = append(result.WeakKeys, fmt.Sprintf("#%v %v", i, weakness))
+		}
+		// The signature on a self signed root is never checked, so it doesn't matter
+		if !bytes.Equal(cert.RawSubject, cert.RawIssuer) && weaksignature(cert.SignatureAlgorithm) {
+			result.WeakSignatures = append(result.WeakSignatures, fmt.Sprintf("#%v %v", i, cert.SignatureAlgorithm))
+		}
+	}
+
+	return result
+}
+
+// weakkey returns a description of the public key if it's considered weak, otherwise blank
+func weakkey(cert *x509.Certificate) string {
+	keytype, bits := turbograb.KeyInfo(cert)
+	switch {
+	case keytype == "RSA" && bits < 2048,
+		keytype == "ECDSA" && bits < 256,
+		keytype == "DSA":
+		return fmt.Sprintf("%v %v bits", keytype, bits)
+	}
+	return ""
+}
+
+func weaksignature(algo x509.SignatureAlgorithm) bool {
+	switch algo {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return true
+	}
+	return false
+}
@@ -10,6 +10,7 @@ require (
 	github.com/schollz/progressbar/v3 v3.14.1
 	github.com/spf13/pflag v1.0.5
 	github.com/valyala/fasthttp v1.51.0
+	golang.org/x/net v0.20.0
 )
 
 require (
@@ -0,0 +1,141 @@
+package turbograb
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// HTMLMetadata is the structured information from the head and links of a web page
+type HTMLMetadata struct {
+	Title       string            `json:"title,omitempty" bson:"title,omitempty"`
+	Description string            `json:"description,omitempty" bson:"description,omitempty"`
+	Generator   string            `json:"generator,omitempty" bson:"generator,omitempty"`
+	Canonical   string            `json:"canonical,omitempty" bson:"canonical,omitempty"`
+	Icon        string            `json:"icon,omitempty" bson:"icon,omitempty"`
+	OpenGraph   map[string]string `json:"opengraph,omitempty" bson:"opengraph,omitempty"` // og: and article: properties
+	Language    string            `json:"language,omitempty" bson:"language,omitempty"`
+	Forms       int               `json:"forms,omitempty" bson:"forms,omitempty"`
+	Scripts     []string          `json:"scripts,omitempty" bson:"scripts,omitempty"`
+	Stylesheets []string          `json:"stylesheets,omitempty" bson:"stylesheets,omitempty"`
+	LinkHosts   []string          `json:"linkhosts,omitempty" bson:"linkhosts,omitempty"` // hosts of links to other sites
+}
+
+// ParseHTML extracts the metadata from body, URLs are made absolute using the page URL
+func ParseHTML(pageurl, body string) *HTMLMetadata {
+	base, _ := url.Parse(pageurl)
+	if base == nil {
+		base = &url.URL{}
+	}
+	resolve := func(ref string) string {
+		u, err := url.Parse(strings.TrimSpace(ref))
+		if err != nil {
+			return ""
+		}
+		return base.ResolveReference(u).String()
+	}
+
+	pagehost := base.Host
+
+	var md HTMLMetadata
+	linkhosts := make(map[string]struct{})
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		name, hasattr := tokenizer.TagName()
+		attrs := make(map[string]string)
+		for hasattr {
+			var key, value []byte
+			key, value, hasattr = tokenizer.TagAttr()
+			if _, found := attrs[string(key)]; !found {
+				attrs[string(key)] = string(value)
+			}
+		}
+
+		switch string(name) {
+		case "html":
+			if md.Language == "" {
+				md.Language = strings.TrimSpace(attrs["lang"])
+			}
+		case "base":
+			if href, found := attrs["href"]; found {
+				if u, err := url.Parse(resolve(href)); err == nil {
+					base = u
+				}
+			}
+		case "title":
+			if md.Title == "" && tokenizer.Next() == html.TextToken {
+				md.Title = strings.Join(strings.Fields(string(tokenizer.Text())), " ")
+			}
+		case "meta":
+			content := strings.TrimSpace(attrs["content"])
+			property := strings.ToLower(attrs["property"])
+			switch strings.ToLower(attrs["name"]) {
+			case "description":
+				md.Description = content
+			case "generator":
+				md.Generator = content
+			default:
+				if strings.HasPrefix(property, "og:") || strings.HasPrefix(property, "article:") {
+					if md.OpenGraph == nil {
+						md.OpenGraph = make(map[string]string)
+					}
+					md.OpenGraph[property] = content
+				}
+			}
+			if strings.EqualFold(attrs["http-equiv"], "content-language") && md.Language == "" {
+				md.Language = content
+			}
+		case "link":
+			href, found := attrs["href"]
+			if !found {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+				switch rel {
+				case "canonical":
+					md.Canonical = resolve(href)
+				case "icon":
+					if md.Icon == "" {
+						md.Icon = resolve(href)
+					}
+				case "stylesheet":
+					md.Stylesheets = appendunique(md.Stylesheets, resolve(href))
+				}
+			}
+		case "script":
+			if src, found := attrs["src"]; found {
+				md.Scripts = appendunique(md.Scripts, resolve(src))
+			}
+		case "form":
+			md.Forms++
+		case "a", "area":
+			if href, found := attrs["href"]; found {
+				if u, err := url.Parse(resolve(href)); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && !strings.EqualFold(u.Host, pagehost) {
+					linkhosts[strings.ToLower(u.Hostname())] = struct{}{}
+				}
+			}
+		}
+	}
+
+	for host := range linkhosts {
+		md.LinkHosts = append(md.LinkHosts, host)
+	}
+	slices.Sort(md.LinkHosts)
+	return &md
+}
+
+func appendunique(list []string, value string) []string {
+	if value == "" || slices.Contains(list, value) {
+		return list
+	}
+	return append(list, value)
+}
@@ -0,0 +1,63 @@
+package turbograb
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// Reader reads results back from the JSON files turbograb writes, certificates are
+// returned as x509.Certificates again
+type Reader struct {
+	decoder *json.Decoder
+	closer  io.Closer
+	store   *CertStore
+}
+
+// NewReader reads JSON results from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		decoder: json.NewDecoder(r),
+	}
+}
+
+// OpenFile opens a JSON result file, decompressing it if the name ends in .lz4
+func OpenFile(filename string) (*Reader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = f
+	if strings.HasSuffix(strings.ToLower(filename), ".lz4") {
+		r = lz4.NewReader(f)
+	}
+	reader := NewReader(r)
+	reader.closer = f
+	return reader, nil
+}
+
+// UseCertStore makes Read look up certificates that were written to store instead of the results
+func (r *Reader) UseCertStore(store *CertStore) {
+	r.store = store
+}
+
+// Read returns the next result, or io.EOF when there are no more
+func (r *Reader) Read() (Result, error) {
+	var result Result
+	err := r.decoder.Decode(&result)
+	if err == nil && r.store != nil {
+		err = r.store.Resolve(&result)
+	}
+	return result, err
+}
+
+// Close closes the underlying file if the reader was made with OpenFile
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
@@ -0,0 +1,298 @@
+package turbograb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TechRules detects technologies from grabbed results. The rules are JSON in the same layout as
+// Wappalyzer, a technology name mapping to the patterns that give it away:
+//
+//	{"WordPress": {
+//	    "headers": {"X-Powered-By": "WordPress"},
+//	    "cookies": {"wordpress_test_cookie": ""},
+//	    "html": ["<link[^>]+/wp-content/"],
+//	    "meta": {"generator": "^WordPress ?([\\d.]+)?\\;version:\\1"},
+//	    "scriptSrc": ["/wp-includes/"],
+//	    "favicon": ["<md5 of favicon>"],
+//	    "implies": ["PHP"]}}
+//
+// Patterns are case insensitive regular expressions, an empty one just has to be present. They can end
+// with \;version:\1 to take the version from a capture group, and \;confidence:50 for a partial match.
+// Patterns Go can't compile, like lookaheads and backreferences, are left out and listed in Skipped.
+type TechRules struct {
+	Skipped []string
+
+	techs []*techrule
+}
+
+type techrule struct {
+	name     string
+	headers  map[string][]*techpattern
+	cookies  map[string][]*techpattern
+	html     []*techpattern
+	meta     map[string][]*techpattern
+	scripts  []*techpattern
+	favicons []string
+	implies  []string
+}
+
+type techpattern struct {
+	re         *regexp.Regexp
+	version    string
+	confidence int
+}
+
+// patternlist is a pattern or a list of them
+type patternlist []string
+
+func (pl *patternlist) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*pl = patternlist{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(pl))
+}
+
+type techrulejson struct {
+	Headers   map[string]patternlist `json:"headers"`
+	Cookies   map[string]patternlist `json:"cookies"`
+	HTML      patternlist            `json:"html"`
+	Meta      map[string]patternlist `json:"meta"`
+	ScriptSrc patternlist            `json:"scriptSrc"`
+	Favicon   patternlist            `json:"favicon"`
+	Implies   patternlist            `json:"implies"`
+}
+
+// LoadTechRules reads rules from a JSON file
+func LoadTechRules(filename string) (*TechRules, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTechRules(data)
+}
+
+// ParseTechRules compiles rules from JSON
+func ParseTechRules(data []byte) (*TechRules, error) {
+	var raw map[string]techrulejson
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	var rules TechRules
+	for name, rj := range raw {
+		tr := &techrule{
+			name:    name,
+			implies: rj.Implies,
+		}
+		var skipped []error
+		tr.headers = compiletechmap(rj.Headers, &skipped)
+		tr.cookies = compiletechmap(rj.Cookies, &skipped)
+		tr.meta = compiletechmap(rj.Meta, &skipped)
+		tr.html = compiletechpatterns(rj.HTML, &skipped)
+		tr.scripts = compiletechpatterns(rj.ScriptSrc, &skipped)
+		for _, err := range skipped {
+			rules.Skipped = append(rules.Skipped, fmt.Sprintf("%v: %v", name, err))
+		}
+		for _, favicon := range rj.Favicon {
+			tr.favicons = append(tr.favicons, strings.ToLower(favicon))
+		}
+		rules.techs = append(rules.techs, tr)
+	}
+	slices.SortFunc(rules.techs, func(a, b *techrule) int {
+		return strings.Compare(a.name, b.name)
+	})
+	slices.Sort(rules.Skipped)
+	return &rules, nil
+}
+
+func compiletechmap(raw map[string]patternlist, skipped *[]error) map[string][]*techpattern {
+	if len(raw) == 0 {
+		return nil
+	}
+	result := make(map[string][]*techpattern)
+	for key, patterns := range raw {
+		if compiled := compiletechpatterns(patterns, skipped); len(compiled) > 0 {
+			result[strings.ToLower(key)] = compiled
+		}
+	}
+	return result
+}
+
+// compiletechpatterns compiles the patterns that it can, and adds errors for the rest to skipped
+func compiletechpatterns(patterns []string, skipped *[]error) []*techpattern {
+	var result []*techpattern
+	for _, pattern := range patterns {
+		tp := &techpattern{
+			confidence: 100,
+		}
+		parts := strings.Split(pattern, `\;`)
+		for _, part := range parts[1:] {
+			key, value, _ := strings.Cut(part, ":")
+			switch key {
+			case "version":
+				tp.version = value
+			case "confidence":
+				if confidence, err := strconv.Atoi(value); err == nil {
+					tp.confidence = confidence
+				}
+			}
+		}
+		re, err := regexp.Compile("(?i)" + parts[0])
+		if err != nil {
+			*skipped = append(*skipped, fmt.Errorf("invalid pattern %v: %v", pattern, err))
+			continue
+		}
+		tp.re = re
+		result = append(result, tp)
+	}
+	return result
+}
+
+// match returns if value matches, and the version if the pattern has one
+func (tp *techpattern) match(value string) (bool, string) {
+	groups := tp.re.FindStringSubmatch(value)
+	if groups == nil {
+		return false, ""
+	}
+	version := tp.version
+	for i := len(groups) - 1; i > 0; i-- {
+		version = strings.ReplaceAll(version, `\`+strconv.Itoa(i), groups[i])
+	}
+	return true, strings.TrimSpace(version)
+}
+
+// Detect returns the technologies found in result, using the headers, body, HTML metadata and favicon hash.
+// Pages without parsed metadata are parsed if they look like HTML.
+func (tr *TechRules) Detect(result *Result) []Technology {
+	headers, cookies := parseheaders(result.Header)
+
+	md := result.HTML
+	if md == nil && (strings.Contains(strings.ToLower(headers["content-type"]), "html") || strings.Contains(strings.ToLower(result.Body[:min(len(result.Body), 512)]), "<html")) {
+		md = ParseHTML(result.URL, result.Body)
+	}
+	var meta map[string]string
+	var scripts []string
+	if md != nil {
+		meta = map[string]string{
+			"generator":   md.Generator,
+			"description": md.Description,
+		}
+		for property, value := range md.OpenGraph {
+			meta[property] = value
+		}
+		scripts = md.Scripts
+	}
+
+	found := make(map[string]*Technology)
+	var order []string
+	add := func(name, version string, confidence int) {
+		t := found[name]
+		if t == nil {
+			t = &Technology{Name: name}
+			found[name] = t
+			order = append(order, name)
+		}
+		t.Confidence = min(t.Confidence+confidence, 100)
+		if len(version) > len(t.Version) {
+			t.Version = version
+		}
+	}
+	check := func(name string, patterns []*techpattern, values ...string) {
+		for _, tp := range patterns {
+			for _, value := range values {
+				if matched, version := tp.match(value); matched {
+					add(name, version, tp.confidence)
+					break
+				}
+			}
+		}
+	}
+
+	for _, rule := range tr.techs {
+		for header, patterns := range rule.headers {
+			if value, present := headers[header]; present {
+				check(rule.name, patterns, value)
+			}
+		}
+		for cookie, patterns := range rule.cookies {
+			if value, present := cookies[cookie]; present {
+				check(rule.name, patterns, value)
+			}
+		}
+		for name, patterns := range rule.meta {
+			if value := meta[name]; value != "" {
+				check(rule.name, patterns, value)
+			}
+		}
+		if result.Body != "" {
+			check(rule.name, rule.html, result.Body)
+		}
+		check(rule.name, rule.scripts, scripts...)
+		if result.FaviconHash != "" && slices.Contains(rule.favicons, result.FaviconHash) {
+			add(rule.name, "", 100)
+		}
+	}
+
+	// Technologies bring what they're built on along
+	implied := make(map[string][]string)
+	for _, rule := range tr.techs {
+		implied[rule.name] = rule.implies
+	}
+	for i := 0; i < len(order); i++ {
+		for _, implication := range implied[order[i]] {
+			name, options, _ := strings.Cut(implication, `\;`)
+			if found[name] != nil {
+				continue
+			}
+			confidence := found[order[i]].Confidence
+			if value, ok := strings.CutPrefix(options, "confidence:"); ok {
+				if partial, err := strconv.Atoi(value); err == nil {
+					confidence = confidence * partial / 100
+				}
+			}
+			add(name, "", confidence)
+		}
+	}
+
+	var technologies []Technology
+	for _, name := range order {
+		technologies = append(technologies, *found[name])
+	}
+	slices.SortFunc(technologies, func(a, b Technology) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return technologies
+}
+
+// parseheaders splits a raw response header into lowercase header names and values, and cookie names and values
+func parseheaders(raw string) (map[string]string, map[string]string) {
+	headers := make(map[string]string)
+	cookies := make(map[string]string)
+	for _, line := range strings.Split(raw, "\n") {
+		key, value, found := strings.Cut(strings.TrimRight(line, "\r"), ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if key == "set-cookie" {
+			cookie, _, _ := strings.Cut(value, ";")
+			name, value, _ := strings.Cut(cookie, "=")
+			cookies[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+			continue
+		}
+		if existing, present := headers[key]; present {
+			value = existing + ", " + value
+		}
+		headers[key] = value
+	}
+	return headers, cookies
+}
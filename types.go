@@ -1,23 +1,109 @@
 package turbograb
 
-import "crypto/x509"
+import (
+	"crypto/tls"
+	"time"
+)
 
 //go:generate easyjson types.go
 
 //easyjson:json
 type Result struct {
-	Site         string              `json:"site,omitempty" bson:"site,omitempty"`
-	URL          string              `json:"url,omitempty" bson:"url,omitempty"`
-	IPaddress    string              `json:"ip,omitempty" bson:"ip,omitempty"`
-	Code         int                 `json:"resultcode,omitempty" bson:"resultcode,omitempty"`
-	Certificates []*x509.Certificate `json:"certificates,omitempty" bson:"certificates,omitempty"`
-	Error        string              `json:"error,omitempty" bson:"error,omitempty"`
-	Warnings     []string            `json:"warnings,omitempty" bson:"warnings,omitempty"`
-	Body         string              `json:"body,omitempty" bson:"body,omitempty"`
-	Header       string              `json:"headers,omitempty" bson:"headers,omitempty"`
+	Site            string        `json:"site,omitempty" bson:"site,omitempty"`
+	URL             string        `json:"url,omitempty" bson:"url,omitempty"`
+	IPaddress       string        `json:"ip,omitempty" bson:"ip,omitempty"`
+	Protocol        string        `json:"protocol,omitempty" bson:"protocol,omitempty"`
+	Code            int           `json:"resultcode,omitempty" bson:"resultcode,omitempty"`
+	Certificates    Certificates  `json:"certificates,omitempty" bson:"certificates,omitempty"`
+	CertificateRefs []string      `json:"certificaterefs,omitempty" bson:"certificaterefs,omitempty"` // fingerprints of certificates in a CertStore
+	Error           string        `json:"error,omitempty" bson:"error,omitempty"`
+	Warnings        []string      `json:"warnings,omitempty" bson:"warnings,omitempty"`
+	Body            string        `json:"body,omitempty" bson:"body,omitempty"`
+	Header          string        `json:"headers,omitempty" bson:"headers,omitempty"`
+	Banner          string        `json:"banner,omitempty" bson:"banner,omitempty"`
+	Raw             []byte        `json:"raw,omitempty" bson:"raw,omitempty"`
+	Timings         *Timings      `json:"timings,omitempty" bson:"timings,omitempty"`
+	TLS             *TLSInfo      `json:"tls,omitempty" bson:"tls,omitempty"`
+	Validation      *Validation   `json:"validation,omitempty" bson:"validation,omitempty"`
+	Hit             bool          `json:"hit,omitempty" bson:"hit,omitempty"` // the path was really there, not a soft 404
+	JARM            string        `json:"jarm,omitempty" bson:"jarm,omitempty"`
+	TLSSupport      []TLSSupport  `json:"tlssupport,omitempty" bson:"tlssupport,omitempty"`
+	Depth           int           `json:"depth,omitempty" bson:"depth,omitempty"`   // links followed from the site's own paths to get here
+	Robots          string        `json:"robots,omitempty" bson:"robots,omitempty"` // what robots.txt said about the path: allowed, delayed or disallowed
+	HTML            *HTMLMetadata `json:"html,omitempty" bson:"html,omitempty"`
+	FaviconHash     string        `json:"faviconhash,omitempty" bson:"faviconhash,omitempty"` // MD5 of the site's favicon in hex
+	Technologies    []Technology  `json:"technologies,omitempty" bson:"technologies,omitempty"`
+}
+
+// Technology is something a site was found to run, Confidence is 1-100
+type Technology struct {
+	Name       string `json:"name" bson:"name"`
+	Version    string `json:"version,omitempty" bson:"version,omitempty"`
+	Confidence int    `json:"confidence" bson:"confidence"`
+}
+
+// Timings breaks down where the time for a grab went. The phase durations
+// are for the final attempt, Total is wall clock time across all attempts.
+type Timings struct {
+	DNS          time.Duration `json:"dns,omitempty" bson:"dns,omitempty"`
+	Connect      time.Duration `json:"connect,omitempty" bson:"connect,omitempty"`
+	TLSHandshake time.Duration `json:"tlshandshake,omitempty" bson:"tlshandshake,omitempty"`
+	FirstByte    time.Duration `json:"firstbyte,omitempty" bson:"firstbyte,omitempty"`
+	Transfer     time.Duration `json:"transfer,omitempty" bson:"transfer,omitempty"`
+	Total        time.Duration `json:"total,omitempty" bson:"total,omitempty"`
+	Attempts     int           `json:"attempts,omitempty" bson:"attempts,omitempty"`
+}
+
+// TLSInfo describes the TLS session negotiated on the connection the result was grabbed from
+type TLSInfo struct {
+	Version      string   `json:"version,omitempty" bson:"version,omitempty"`
+	CipherSuite  string   `json:"ciphersuite,omitempty" bson:"ciphersuite,omitempty"`
+	ALPN         string   `json:"alpn,omitempty" bson:"alpn,omitempty"`
+	ServerName   string   `json:"servername,omitempty" bson:"servername,omitempty"`
+	Resumed      bool     `json:"resumed,omitempty" bson:"resumed,omitempty"`
+	OCSPResponse []byte   `json:"ocspresponse,omitempty" bson:"ocspresponse,omitempty"`
+	SCTs         [][]byte `json:"scts,omitempty" bson:"scts,omitempty"`
+}
+
+// NewTLSInfo extracts the interesting parts of a connection state
+func NewTLSInfo(state tls.ConnectionState) *TLSInfo {
+	return &TLSInfo{
+		Version:      tls.VersionName(state.Version),
+		CipherSuite:  tls.CipherSuiteName(state.CipherSuite),
+		ALPN:         state.NegotiatedProtocol,
+		ServerName:   state.ServerName,
+		Resumed:      state.DidResume,
+		OCSPResponse: state.OCSPResponse,
+		SCTs:         state.SignedCertificateTimestamps,
+	}
+}
+
+// TLSSupport is whether a server accepted a protocol version, and the cipher suites it accepted with it
+type TLSSupport struct {
+	Version      string   `json:"version" bson:"version"`
+	Accepted     bool     `json:"accepted" bson:"accepted"`
+	CipherSuites []string `json:"ciphersuites,omitempty" bson:"ciphersuites,omitempty"`
+}
+
+// Validation is the result of checking the certificate chain the server presented,
+// regardless of whether we had to skip verification to grab the content
+type Validation struct {
+	ChainValid     bool      `json:"chainvalid" bson:"chainvalid"`
+	CABundleValid  bool      `json:"cabundlevalid,omitempty" bson:"cabundlevalid,omitempty"`
+	Hostname       string    `json:"hostname,omitempty" bson:"hostname,omitempty"`
+	HostnameValid  bool      `json:"hostnamevalid" bson:"hostnamevalid"`
+	NotBefore      time.Time `json:"notbefore" bson:"notbefore"`
+	NotAfter       time.Time `json:"notafter" bson:"notafter"`
+	Expired        bool      `json:"expired,omitempty" bson:"expired,omitempty"`
+	NotYetValid    bool      `json:"notyetvalid,omitempty" bson:"notyetvalid,omitempty"`
+	WeakKeys       []string  `json:"weakkeys,omitempty" bson:"weakkeys,omitempty"`
+	WeakSignatures []string  `json:"weaksignatures,omitempty" bson:"weaksignatures,omitempty"`
+	Errors         []string  `json:"errors,omitempty" bson:"errors,omitempty"`
 }
 
 type Encoded struct {
-	Site string
-	Data []byte
+	Site    string
+	Part    int // batches for the same site after the first go in files of their own
+	Records int
+	Data    []byte
 }